@@ -0,0 +1,278 @@
+package next
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// An AccessEntry describes a request, or a TCP message, for the access
+// log.
+type AccessEntry struct {
+	Time      time.Time
+	RequestID string
+	Client    string
+	// Method is "TCP" for TCP messages, whose method is the Path
+	Method string
+	Path   string
+	// URI is the path with the query string, redacted like Params
+	URI       string
+	Proto     string
+	Status    int
+	Bytes     int64
+	Latency   time.Duration
+	Referer   string
+	UserAgent string
+	// Params are the parameters of the request, the redacted ones masked
+	Params map[string]string
+	// Color reports whether the log goes to a terminal
+	Color bool
+}
+
+// An AccessFormatter makes the access log line of a request.
+type AccessFormatter func(e *AccessEntry) string
+
+const redacted = "[REDACTED]"
+
+// defaultRedact lists the parameters masked in the access log when the
+// log.redact setting is not set.
+var defaultRedact = []string{"password", "passwd", "secret", "token", "csrf_token"}
+
+// DefaultLog writes the time, request ID, client, method, path, status,
+// size, latency and parameters of a request, in colors on a terminal.
+func DefaultLog(e *AccessEntry) string {
+	var b bytes.Buffer
+	color := func(code, s string) string {
+		if !e.Color {
+			return s
+		}
+		return "\033[" + code + "m" + s + "\033[0m"
+	}
+
+	status := "32;1"
+	switch {
+	case e.Status >= 500:
+		status = "31;1"
+	case e.Status >= 400:
+		status = "33;1"
+	}
+	fmt.Fprintf(&b, "%s [%s] %s - %s - %s - %dB - %v",
+		e.Time.Format("2006/01/02 15:04:05"), e.RequestID, e.Client,
+		color("32;1", e.Method+" "+e.Path), color(status, fmt.Sprint(e.Status)), e.Bytes, e.Latency)
+	if len(e.Params) > 0 {
+		b.WriteString(" - " + color("37;1", fmt.Sprintf("Params: %v", e.Params)))
+	}
+	return b.String()
+}
+
+// CommonLog writes a request in the Common Log Format of Apache:
+//
+//	127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.1" 200 2326
+func CommonLog(e *AccessEntry) string {
+	size := "-"
+	if e.Bytes > 0 {
+		size = fmt.Sprint(e.Bytes)
+	}
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s", e.Client,
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"), e.Method, e.URI, e.Proto, e.Status, size)
+}
+
+// CombinedLog writes a request in the Combined Log Format of Apache, the
+// Common Log Format followed by the referer and the user agent.
+func CombinedLog(e *AccessEntry) string {
+	return fmt.Sprintf("%s %q %q", CommonLog(e), orDash(e.Referer), orDash(e.UserAgent))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// JSONLog writes a request as a JSON object, for log collectors.
+func JSONLog(e *AccessEntry) string {
+	b, _ := json.Marshal(map[string]interface{}{
+		"time":       e.Time.Format(time.RFC3339Nano),
+		"request_id": e.RequestID,
+		"client":     e.Client,
+		"method":     e.Method,
+		"path":       e.Path,
+		"uri":        e.URI,
+		"proto":      e.Proto,
+		"status":     e.Status,
+		"bytes":      e.Bytes,
+		"latency_ms": float64(e.Latency) / float64(time.Millisecond),
+		"referer":    e.Referer,
+		"user_agent": e.UserAgent,
+		"params":     e.Params,
+	})
+	return string(b)
+}
+
+// TemplateLog returns a formatter executing a text/template on the
+// AccessEntry of each request:
+//
+//	{{.Time.Unix}} {{.Method}} {{.URI}} {{.Status}} {{.Latency}}
+func TemplateLog(text string) (AccessFormatter, error) {
+	t, err := template.New("access").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return func(e *AccessEntry) string {
+		var b bytes.Buffer
+		if err := t.Execute(&b, e); err != nil {
+			return "access log template: " + err.Error()
+		}
+		return b.String()
+	}, nil
+}
+
+// accessLog is the access log set by the log section of a server config:
+//
+//	"log": {
+//		"format": "combined",
+//		"redact": ["password", "card"]
+//	}
+//
+// format is "default", "common", "combined", "json" or a template, see
+// TemplateLog.
+type accessLog struct {
+	mu     sync.Mutex
+	conf   string
+	format AccessFormatter
+	redact map[string]bool
+	// the file of the logger when last written to, and if it is a terminal
+	file    *os.File
+	checked bool
+	color   bool
+}
+
+func (a *accessLog) load(cfg *Config, logger *log.Logger) (AccessFormatter, map[string]bool) {
+	format := cfg.String("log.format")
+	list := cfg.Strings("log.redact")
+	if list == nil {
+		list = defaultRedact
+	}
+	conf := format + "\x00" + strings.Join(list, ",")
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.format != nil && conf == a.conf {
+		return a.format, a.redact
+	}
+
+	a.conf, a.format = conf, DefaultLog
+	switch format {
+	case "", "default":
+	case "common":
+		a.format = CommonLog
+	case "combined":
+		a.format = CombinedLog
+	case "json":
+		a.format = JSONLog
+	default:
+		if f, err := TemplateLog(format); err != nil {
+			logger.Println("Invalid log format", err)
+		} else {
+			a.format = f
+		}
+	}
+	a.redact = map[string]bool{}
+	for _, name := range list {
+		a.redact[strings.ToLower(name)] = true
+	}
+	return a.format, a.redact
+}
+
+func redactParams(params map[string]string, redact map[string]bool) map[string]string {
+	if len(params) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(params))
+	for k, v := range params {
+		if redact[strings.ToLower(k)] {
+			v = redacted
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func redactURI(u *url.URL, redact map[string]bool) string {
+	if u.RawQuery == "" {
+		return u.RequestURI()
+	}
+	q, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return u.EscapedPath() + "?" + redacted
+	}
+	masked := false
+	for k, vals := range q {
+		if redact[strings.ToLower(k)] {
+			for i := range vals {
+				vals[i] = redacted
+			}
+			masked = true
+		}
+	}
+	if !masked {
+		return u.RequestURI()
+	}
+	return u.EscapedPath() + "?" + q.Encode()
+}
+
+// LogSample logs a share rate, between 0 and 1, of the requests of route
+// r, for busy routes. Requests answered with a 5xx status are all logged.
+func (r *Route) LogSample(rate float64) *Route {
+	r.conf.logSample = rate
+	return r
+}
+
+func sampled(conf *routeConf, status int) bool {
+	if conf == nil || conf.logSample <= 0 || conf.logSample >= 1 || status >= 500 {
+		return true
+	}
+	return rand.Float64() < conf.logSample
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	if f == nil {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// write writes the line of e to the writer of logger, after its prefix, in
+// color when it writes to a terminal, which is looked up when the writer
+// of logger changes only. The flags of logger are left out: the line has
+// a time of its own, and a format like JSON must stay as is.
+func (a *accessLog) write(logger *log.Logger, format AccessFormatter, e *AccessEntry) {
+	w := logger.Writer()
+	f, _ := w.(*os.File)
+	a.mu.Lock()
+	if !a.checked || f != a.file {
+		a.file, a.checked, a.color = f, true, isTerminal(f)
+	}
+	e.Color = a.color
+	a.mu.Unlock()
+
+	line := logger.Prefix() + format(e)
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	io.WriteString(w, line)
+}
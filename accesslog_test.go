@@ -0,0 +1,98 @@
+package next
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func accessLogServer(conf string) (*Server, *strings.Builder) {
+	s := newTestServer()
+	out := new(strings.Builder)
+	// the flags of the logger are left as they are, a date and a time
+	s.Logger.SetOutput(out)
+	s.Config.Read([]byte(conf))
+	s.Get("/hello", func(ctx *Context) string { return "hello" })
+	s.Get("/fail", func(ctx *Context) { ctx.Abort(500, "fail") })
+	return s, out
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	s, out := accessLogServer(`{"log": {"format": "json"}}`)
+	serve(s, "GET", "/hello?password=hunter2&page=2")
+
+	var e map[string]interface{}
+	if err := json.Unmarshal([]byte(out.String()), &e); err != nil {
+		t.Fatalf("log %q: %v", out.String(), err)
+	}
+	if e["status"] != 200.0 || e["bytes"] != 5.0 || e["path"] != "/hello" {
+		t.Errorf("log entry %v", e)
+	}
+	params := e["params"].(map[string]interface{})
+	if params["password"] != "[REDACTED]" || params["page"] != "2" {
+		t.Errorf("params %v", params)
+	}
+	if strings.Contains(out.String(), "hunter2") {
+		t.Errorf("log %q holds a redacted value", out.String())
+	}
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	e := &AccessEntry{
+		Time:      time.Date(2000, 10, 10, 13, 55, 36, 0, time.UTC),
+		Client:    "127.0.0.1",
+		Method:    "GET",
+		Path:      "/index.html",
+		URI:       "/index.html?a=1",
+		Proto:     "HTTP/1.1",
+		Status:    200,
+		Bytes:     2326,
+		UserAgent: "curl",
+	}
+	if got, want := CommonLog(e), `127.0.0.1 - - [10/Oct/2000:13:55:36 +0000] "GET /index.html?a=1 HTTP/1.1" 200 2326`; got != want {
+		t.Errorf("CommonLog = %s, want %s", got, want)
+	}
+	if got, want := CombinedLog(e), `127.0.0.1 - - [10/Oct/2000:13:55:36 +0000] "GET /index.html?a=1 HTTP/1.1" 200 2326 "-" "curl"`; got != want {
+		t.Errorf("CombinedLog = %s, want %s", got, want)
+	}
+	if got := DefaultLog(e); strings.Contains(got, "\033[") {
+		t.Errorf("DefaultLog = %q, colored out of a terminal", got)
+	}
+
+	s, out := accessLogServer(`{"log": {"format": "{{.Method}} {{.Path}} {{.Status}}"}}`)
+	serve(s, "GET", "/fail")
+	if out.String() != "GET /fail 500\n" {
+		t.Errorf("template log %q", out.String())
+	}
+	// the lines go through the logger, prefix included
+	out.Reset()
+	s.Logger.SetPrefix("[web] ")
+	serve(s, "GET", "/fail")
+	if out.String() != "[web] GET /fail 500\n" {
+		t.Errorf("prefixed log %q", out.String())
+	}
+}
+
+func TestAccessLogSample(t *testing.T) {
+	s, out := accessLogServer(`{}`)
+	s.Get("/busy", func(ctx *Context) {}).LogSample(0.000001)
+	for i := 0; i < 10; i++ {
+		serve(s, "GET", "/busy")
+	}
+	if out.Len() != 0 {
+		t.Errorf("sampled route logged %q", out.String())
+	}
+	serve(s, "GET", "/hello")
+	if !strings.Contains(out.String(), "GET /hello") {
+		t.Errorf("log %q", out.String())
+	}
+}
+
+func TestRedactURI(t *testing.T) {
+	req := httptest.NewRequest("GET", "/login?user=bob&Token=x", nil)
+	if got := redactURI(req.URL, map[string]bool{"token": true}); got != "/login?Token=%5BREDACTED%5D&user=bob" {
+		t.Errorf("redactURI = %s", got)
+	}
+}
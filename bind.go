@@ -0,0 +1,391 @@
+package next
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// FieldError describes a field of a struct failing a rule of its `valid`
+// tag, or a form value that could not be converted to the type of its
+// field, in which case Rule is "type".
+type FieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+	Msg   string `json:"msg"`
+}
+
+// ValidationErrors is returned by Bind and Validate when fields are not
+// valid. It encodes to a JSON list of FieldError, so it can be written as
+// is: ctx.WriteJSON("422", "validation failed", errs). The default error
+// handler answers it that way.
+type ValidationErrors []FieldError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Msg
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Bind decodes the body of the request into dst, a pointer to a struct,
+// according to its Content-Type: JSON, XML, or url-encoded and multipart
+// forms. Without a body, the query string is used. Form values are matched
+// with the `form` tag of the fields, then their `json` tag, then their
+// name. dst is then checked with Validate.
+//
+// A body that does not decode is reported as a 400 *HTTPError.
+func (ctx *Context) Bind(dst interface{}) error {
+	req := ctx.Request
+	ctype, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+	var err error
+	switch {
+	case ctype == "application/json" || strings.HasSuffix(ctype, "+json"):
+		var body []byte
+		if body, err = ctx.Body(); err != nil {
+			return err
+		}
+		err = json.NewDecoder(bytes.NewReader(body)).Decode(dst)
+	case ctype == "application/xml" || ctype == "text/xml" || strings.HasSuffix(ctype, "+xml"):
+		var body []byte
+		if body, err = ctx.Body(); err != nil {
+			return err
+		}
+		err = xml.NewDecoder(bytes.NewReader(body)).Decode(dst)
+	case ctype == "multipart/form-data":
+		if err = req.ParseMultipartForm(32 << 20); err == nil {
+			return bindValues(dst, req.Form)
+		}
+	default:
+		// a streamed body is parsed here
+		if err = req.ParseForm(); err == nil {
+			return bindValues(dst, req.Form)
+		}
+	}
+	if err != nil {
+		he := NewHTTPError(400, "bad_request", "request body can not be decoded")
+		he.Err = err
+		return he
+	}
+	return Validate(dst)
+}
+
+// bindValues sets the fields of dst from form values, then validates it.
+func bindValues(dst interface{}, form url.Values) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		panic("bind destination should be a struct's pointer")
+	}
+
+	var errs ValidationErrors
+	setFields(v.Elem(), form, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return Validate(dst)
+}
+
+func setFields(v reflect.Value, form url.Values, errs *ValidationErrors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		fv := v.Field(i)
+		if f.Anonymous && fv.Kind() == reflect.Struct {
+			setFields(fv, form, errs)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := tagName(f, "form", "json")
+		vals, ok := form[name]
+		if name == "-" || !ok || len(vals) == 0 {
+			continue
+		}
+
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			s := reflect.MakeSlice(fv.Type(), len(vals), len(vals))
+			for j, val := range vals {
+				if err := setValue(s.Index(j), val); err != nil {
+					*errs = append(*errs, FieldError{name, "type", fmt.Sprintf("%s: %v", name, err)})
+				}
+			}
+			fv.Set(s)
+			continue
+		}
+		if err := setValue(fv, vals[0]); err != nil {
+			*errs = append(*errs, FieldError{name, "type", fmt.Sprintf("%s: %v", name, err)})
+		}
+	}
+}
+
+func setValue(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Ptr {
+		p := reflect.New(v.Type().Elem())
+		if err := setValue(p.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a positive integer", s)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a number", s)
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		// []byte
+		v.SetBytes([]byte(s))
+	default:
+		return fmt.Errorf("can not bind to %s", v.Type())
+	}
+	return nil
+}
+
+// tagName returns the name given to field f by the first of tags it has,
+// or the name of the field.
+func tagName(f reflect.StructField, tags ...string) string {
+	for _, tag := range tags {
+		if name := strings.Split(f.Tag.Get(tag), ",")[0]; name != "" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+// Validate checks the fields of v, a struct or a pointer to one, against
+// the rules of their `valid` tag, separated by commas:
+//
+//	required      the field is not its zero value
+//	min=N, max=N  bounds of a number, or of the length of a string, slice or map
+//	enum=a|b|c    the field is one of the values
+//	regexp=EXPR   a string field matches EXPR; it has to be the last rule
+//
+// enum and regexp accept a field left empty, which required rejects.
+// Fields are named by their `json` tag in the errors. Nested structs are
+// validated too. The result is nil or ValidationErrors, or another error
+// for a tag with a bad bound or expression.
+func Validate(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs ValidationErrors
+	if err := validateStruct(rv, "", &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateStruct(v reflect.Value, prefix string, errs *ValidationErrors) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		fv := v.Field(i)
+
+		name := prefix + tagName(f, "json", "form")
+		if f.Anonymous {
+			name = prefix
+		}
+		for _, rule := range parseRules(f.Tag.Get("valid")) {
+			msg, err := rule.check(fv)
+			if err != nil {
+				return fmt.Errorf("next: valid tag of %s.%s: %v", t, f.Name, err)
+			}
+			if msg != "" {
+				field := strings.TrimSuffix(name, ".")
+				*errs = append(*errs, FieldError{field, rule.name, field + " " + msg})
+			}
+		}
+
+		for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Struct {
+			if !f.Anonymous {
+				name += "."
+			}
+			if err := validateStruct(fv, name, errs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type rule struct {
+	name string
+	arg  string
+}
+
+func parseRules(tag string) []rule {
+	var rules []rule
+	for tag != "" {
+		var r string
+		if strings.HasPrefix(tag, "regexp=") {
+			r, tag = tag, ""
+		} else if i := strings.IndexByte(tag, ','); i >= 0 {
+			r, tag = tag[:i], tag[i+1:]
+		} else {
+			r, tag = tag, ""
+		}
+
+		name, arg := r, ""
+		if i := strings.IndexByte(r, '='); i >= 0 {
+			name, arg = r[:i], r[i+1:]
+		}
+		rules = append(rules, rule{strings.TrimSpace(name), arg})
+	}
+	return rules
+}
+
+// compiled regexp rules
+var ruleRegexps struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}
+
+// check returns why v breaks the rule, or an empty string. The error is
+// for a rule with a bad argument.
+func (r rule) check(v reflect.Value) (string, error) {
+	if r.name == "required" {
+		if v.IsZero() {
+			return "is required", nil
+		}
+		return "", nil
+	}
+
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			// only required applies to a missing value
+			return "", nil
+		}
+		v = v.Elem()
+	}
+
+	switch r.name {
+	case "min", "max":
+		bound, err := strconv.ParseFloat(r.arg, 64)
+		if err != nil {
+			return "", fmt.Errorf("bad %s value %q", r.name, r.arg)
+		}
+		n, unit := measure(v)
+		if r.name == "min" && n < bound {
+			return fmt.Sprintf("must be at least %s%s", r.arg, unit), nil
+		}
+		if r.name == "max" && n > bound {
+			return fmt.Sprintf("must be at most %s%s", r.arg, unit), nil
+		}
+	case "enum":
+		if v.IsZero() {
+			// left empty, only required applies
+			return "", nil
+		}
+		s := fmt.Sprint(v.Interface())
+		for _, e := range strings.Split(r.arg, "|") {
+			if s == e {
+				return "", nil
+			}
+		}
+		return "must be one of " + strings.Replace(r.arg, "|", ", ", -1), nil
+	case "regexp":
+		if v.Kind() != reflect.String || v.Len() == 0 {
+			return "", nil
+		}
+		re, err := ruleRegexp(r.arg)
+		if err != nil {
+			return "", err
+		}
+		if !re.MatchString(v.String()) {
+			return "has an invalid format", nil
+		}
+	}
+	return "", nil
+}
+
+// ruleRegexp returns the compiled expr, compiling it once.
+func ruleRegexp(expr string) (*regexp.Regexp, error) {
+	ruleRegexps.Lock()
+	re, ok := ruleRegexps.m[expr]
+	ruleRegexps.Unlock()
+	if ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	ruleRegexps.Lock()
+	defer ruleRegexps.Unlock()
+	if ruleRegexps.m == nil {
+		ruleRegexps.m = make(map[string]*regexp.Regexp)
+	}
+	ruleRegexps.m[expr] = re
+	return re, nil
+}
+
+// measure returns the value of a number, or the length of anything else.
+func measure(v reflect.Value) (float64, string) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), ""
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), " items"
+	}
+	return 0, ""
+}
@@ -0,0 +1,120 @@
+package next
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type signup struct {
+	Name  string   `json:"name" valid:"required,min=3,max=10"`
+	Age   int      `json:"age" valid:"min=18"`
+	Role  string   `json:"role" valid:"enum=admin|user"`
+	Email string   `json:"email" valid:"required,regexp=^[^@]+@[^@]+$"`
+	Tags  []string `json:"tags" form:"tag" valid:"max=2"`
+}
+
+func bindRequest(s *Server, ctype, body string, dst interface{}) error {
+	var err error
+	s.Post("/", func(ctx *Context) {
+		err = ctx.Bind(dst)
+	})
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", ctype)
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	return err
+}
+
+func TestBindJSON(t *testing.T) {
+	var dst signup
+	err := bindRequest(newTestServer(), "application/json",
+		`{"name": "fred", "age": 20, "role": "admin", "email": "fred@example.com"}`, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.Name != "fred" || dst.Age != 20 {
+		t.Errorf("bound %+v", dst)
+	}
+}
+
+func TestBindForm(t *testing.T) {
+	var dst signup
+	err := bindRequest(newTestServer(), "application/x-www-form-urlencoded",
+		"name=fred&age=20&role=user&email=fred@example.com&tag=a&tag=b", &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.Name != "fred" || dst.Age != 20 || len(dst.Tags) != 2 {
+		t.Errorf("bound %+v", dst)
+	}
+}
+
+func TestBindValidation(t *testing.T) {
+	var dst signup
+	err := bindRequest(newTestServer(), "application/json",
+		`{"name": "fr", "age": 12, "role": "root", "tags": ["a", "b", "c"]}`, &dst)
+
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("error = %v, want ValidationErrors", err)
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Field+":"+e.Rule)
+	}
+	want := "name:min,age:min,role:enum,email:required,tags:max"
+	if strings.Join(got, ",") != want {
+		t.Errorf("errors = %v, want %s", got, want)
+	}
+}
+
+func TestBindBadBody(t *testing.T) {
+	var dst signup
+	err := bindRequest(newTestServer(), "application/json", `{"name": `, &dst)
+	if he, ok := err.(*HTTPError); !ok || he.Status != 400 {
+		t.Errorf("error = %v, want a 400 HTTPError", err)
+	}
+
+	err = bindRequest(newTestServer(), "application/x-www-form-urlencoded", "age=old", &dst)
+	if errs, ok := err.(ValidationErrors); !ok || errs[0].Rule != "type" {
+		t.Errorf("error = %v, want a type error", err)
+	}
+}
+
+func TestValidationErrorResponse(t *testing.T) {
+	s := newTestServer()
+	s.Post("/", func(ctx *Context) error {
+		var dst signup
+		return ctx.Bind(&dst)
+	})
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"name": "fred", "email": "x@y", "role": "root"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	want := `{"code":"422","data":[{"field":"age","rule":"min","msg":"age must be at least 18"},` +
+		`{"field":"role","rule":"enum","msg":"role must be one of admin, user"}],"msg":"validation failed"}`
+	if w.Code != 422 || w.Body.String() != want {
+		t.Errorf("got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestValidateBadTag(t *testing.T) {
+	type badRegexp struct {
+		Code string `valid:"regexp=([a-z"`
+	}
+	type badMin struct {
+		Age int `valid:"min=ten"`
+	}
+	// twice: a bad expression must not leave the cache locked
+	for i := 0; i < 2; i++ {
+		err := Validate(&badRegexp{Code: "abc"})
+		if _, ok := err.(ValidationErrors); err == nil || ok {
+			t.Errorf("bad regexp: got %v", err)
+		}
+	}
+	if err := Validate(&badMin{Age: 3}); err == nil || !strings.Contains(err.Error(), "min") {
+		t.Errorf("bad min: got %v", err)
+	}
+}
@@ -0,0 +1,385 @@
+package next
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"github.com/andybalholm/brotli"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// An Encoder returns a writer compressing what it is given to w, at a
+// level of its own, -1 standing for its default.
+type Encoder func(w io.Writer, level int) (io.WriteCloser, error)
+
+var encoders = struct {
+	sync.RWMutex
+	m map[string]Encoder
+}{m: map[string]Encoder{
+	"gzip": func(w io.Writer, level int) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, level)
+	},
+	// the deflate content coding is zlib data, RFC 9110 8.4.1.2
+	"deflate": func(w io.Writer, level int) (io.WriteCloser, error) {
+		return zlib.NewWriterLevel(w, level)
+	},
+	"br": func(w io.Writer, level int) (io.WriteCloser, error) {
+		if level < 0 {
+			level = brotli.DefaultCompression
+		}
+		return brotli.NewWriterLevel(w, level), nil
+	},
+}}
+
+// RegisterEncoding makes the content coding name available to the
+// Compress middleware, which supports br, gzip and deflate itself, or
+// replaces one of them. It is to be listed in CompressOptions.Encodings
+// then:
+//
+//	next.RegisterEncoding("zstd", func(w io.Writer, level int) (io.WriteCloser, error) {
+//		return zstd.NewWriter(w)
+//	})
+//	s.Use(next.Compress(next.CompressOptions{Encodings: []string{"zstd", "br", "gzip"}}))
+func RegisterEncoding(name string, enc Encoder) {
+	encoders.Lock()
+	defer encoders.Unlock()
+	encoders.m[name] = enc
+}
+
+func encoder(name string) Encoder {
+	encoders.RLock()
+	defer encoders.RUnlock()
+	return encoders.m[name]
+}
+
+// DefaultCompressTypes are the content types compressed by default.
+var DefaultCompressTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"image/svg+xml",
+}
+
+// CompressOptions configures the Compress middleware.
+type CompressOptions struct {
+	// Encodings lists the content codings used, in order of preference
+	// when the client takes several equally: "br", "gzip" and "deflate" by
+	// default. Codings not registered are left out.
+	Encodings []string
+	// Level is given to the encoder, its default when 0: from 1 to 9 for
+	// gzip and deflate, up to 11 for br.
+	Level int
+	// MinSize is the size of the smallest body compressed, 1024 bytes by
+	// default. Smaller ones are not worth it.
+	MinSize int
+	// Types lists the content types compressed, DefaultCompressTypes by
+	// default. A type ending with "/*" stands for all of its subtypes.
+	Types []string
+}
+
+func (o CompressOptions) withDefaults() CompressOptions {
+	if o.Encodings == nil {
+		o.Encodings = []string{"br", "gzip", "deflate"}
+	}
+	if o.Level == 0 {
+		o.Level = -1
+	}
+	if o.MinSize == 0 {
+		o.MinSize = 1024
+	}
+	if o.Types == nil {
+		o.Types = DefaultCompressTypes
+	}
+	return o
+}
+
+// Compress returns middleware compressing the responses in a coding of
+// the Accept-Encoding header of the request. Bodies under MinSize bytes,
+// of other content types than Types, already encoded, or partial are sent
+// as they are. Responses of the compressed types get Vary:
+// Accept-Encoding; compressed ones lose their Content-Length and their
+// ETag is made weak.
+//
+//	s.Use(next.Compress())
+func Compress(opts ...CompressOptions) Middleware {
+	var o CompressOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	o = o.withDefaults()
+
+	return func(ctx *Context) {
+		orig := ctx.ResponseWriter
+		cw := &compressWriter{
+			ResponseWriter: orig,
+			rw:             ctx.writer,
+			o:              &o,
+			encoding:       o.negotiate(ctx.Request.Header.Get("Accept-Encoding")),
+		}
+		ctx.ResponseWriter = cw
+		defer func() {
+			ctx.ResponseWriter = orig
+			if err := recover(); err != nil {
+				// leave the response to the panic handler
+				cw.discard()
+				if cw.enc != nil {
+					cw.enc.Close()
+				}
+				panic(err)
+			}
+			if err := cw.Close(); err != nil {
+				ctx.Server.Logger.Println("Error during write: ", err)
+			}
+		}()
+		ctx.Next()
+	}
+}
+
+// negotiate returns the coding of the Accept-Encoding header accept to
+// compress in, or "".
+func (o *CompressOptions) negotiate(accept string) string {
+	best, bestQ := "", 0.0
+	for _, name := range o.Encodings {
+		if encoder(name) == nil {
+			continue
+		}
+		if q := encodingQ(accept, name); q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// encodingQ returns the quality of encoding in the Accept-Encoding header
+// accept, that of "*" when it is not named.
+func encodingQ(accept, encoding string) float64 {
+	star := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name != encoding && name != "*" {
+			continue
+		}
+		pq := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					pq = v
+				}
+			}
+		}
+		if name == encoding {
+			return pq
+		}
+		star = pq
+	}
+	return star
+}
+
+func (o *CompressOptions) compressible(contentType string) bool {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	for _, t := range o.Types {
+		if t == contentType || strings.HasSuffix(t, "/*") && strings.HasPrefix(contentType, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter holds back the first MinSize bytes of a response, to
+// tell whether to compress it, before writing the header.
+type compressWriter struct {
+	http.ResponseWriter
+	// the writer of the request, told of a response held back
+	rw       *responseWriter
+	o        *CompressOptions
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	enc      io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if status >= 100 && status < 200 && status != 101 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+		w.held()
+	}
+	if !bodyAllowed(status) {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		if n, err := strconv.Atoi(w.Header().Get("Content-Length")); err == nil && n < w.o.MinSize {
+			w.decide(false)
+		} else {
+			w.buf = append(w.buf, b...)
+			w.held()
+			if len(w.buf) < w.o.MinSize {
+				return len(b), nil
+			}
+			return len(b), w.decide(true)
+		}
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// held tells the writer of the request that a response is held back, so
+// that ctx.Written reports it.
+func (w *compressWriter) held() {
+	if w.rw != nil {
+		w.rw.hold(true)
+	}
+}
+
+// discard drops the response held back, if it is not sent yet, for an
+// error to be sent in its place.
+func (w *compressWriter) discard() {
+	if w.decided {
+		return
+	}
+	w.status, w.buf = 0, nil
+	if w.rw != nil {
+		w.rw.hold(false)
+	}
+}
+
+// decide writes the header, compressing the response when try is true and
+// the response is fit for it, and the bytes held back.
+func (w *compressWriter) decide(try bool) error {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if w.status == 0 {
+		w.status = 200
+	}
+
+	compressible := w.o.compressible(h.Get("Content-Type"))
+	if compressible && !hasToken(h.Values("Vary"), "Accept-Encoding") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if try && compressible && w.encoding != "" && bodyAllowed(w.status) && w.status != 206 &&
+		h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" {
+		enc, err := encoder(w.encoding)(w.ResponseWriter, w.o.Level)
+		if err == nil {
+			w.enc = enc
+			h.Del("Content-Length")
+			h.Set("Content-Encoding", w.encoding)
+			if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				h.Set("ETag", "W/"+etag)
+			}
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Close writes what is held back and ends the compressed stream.
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			// nothing written: leave the response to what comes next
+			return nil
+		}
+		if w.Header().Get("Content-Length") == "" && bodyAllowed(w.status) {
+			w.Header().Set("Content-Length", strconv.Itoa(len(w.buf)))
+		}
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+	return nil
+}
+
+// Flush sends the response so far, compressing it whatever its size: a
+// flushed response is streamed.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("next: the ResponseWriter does not support hijacking")
+	}
+	w.decided = true
+	return hj.Hijack()
+}
+
+func (w *compressWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// bodyAllowed reports whether a response of status may have a body.
+func bodyAllowed(status int) bool {
+	return status == 0 || status >= 200 && status != 204 && status != 304
+}
+
+// hasToken reports whether the comma-separated header values hold token.
+func hasToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
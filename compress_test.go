@@ -0,0 +1,145 @@
+package next
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/andybalholm/brotli"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func compressServer() *Server {
+	s := newTestServer()
+	s.Use(Compress())
+	s.Get("/list", func(ctx *Context) []string {
+		return make([]string, 500)
+	})
+	s.Get("/small", func(ctx *Context) string { return "small" })
+	s.Get("/png", func(ctx *Context) {
+		ctx.ContentType("image/png")
+		ctx.WriteString(strings.Repeat("x", 2048))
+	})
+	return s
+}
+
+func serveEncoded(s *Server, path, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("Accept-Encoding", accept)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func TestCompress(t *testing.T) {
+	s := compressServer()
+
+	w := serveEncoded(s, "/list", "deflate;q=0.5, gzip")
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Content-Length") != "" {
+		t.Fatalf("headers %v", w.Header())
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Vary %q", w.Header().Get("Vary"))
+	}
+	r, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(r)
+	if want := `[""` + strings.Repeat(`,""`, 499) + `]`; string(body) != want {
+		t.Errorf("body %.40q", body)
+	}
+
+	w = serveEncoded(s, "/list", "deflate, gzip;q=0")
+	if w.Header().Get("Content-Encoding") != "deflate" {
+		t.Fatalf("Content-Encoding %q, want deflate", w.Header().Get("Content-Encoding"))
+	}
+	zr, err := zlib.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(zr)
+	if len(body) != 1+500*3-1+1 {
+		t.Errorf("inflated %d bytes", len(body))
+	}
+}
+
+func TestCompressBrotli(t *testing.T) {
+	w := serveEncoded(compressServer(), "/list", "gzip, deflate, br")
+	if w.Header().Get("Content-Encoding") != "br" {
+		t.Fatalf("Content-Encoding %q, want br", w.Header().Get("Content-Encoding"))
+	}
+	body, err := ioutil.ReadAll(brotli.NewReader(w.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[""` + strings.Repeat(`,""`, 499) + `]`; string(body) != want {
+		t.Errorf("body %.40q", body)
+	}
+}
+
+func TestCompressWritten(t *testing.T) {
+	s := newTestServer()
+	s.Use(Compress())
+	var written bool
+	s.Get("/", func(ctx *Context) {
+		ctx.WriteString("held back")
+		written = ctx.Written()
+	})
+	s.Get("/panic", func(ctx *Context) {
+		ctx.WriteString("held back")
+		panic("failed")
+	})
+
+	if w := serveEncoded(s, "/", "gzip"); !written || w.Body.String() != "held back" {
+		t.Errorf("Written %v, body %q", written, w.Body.String())
+	}
+	// what is held back is dropped for the error
+	if w := serveEncoded(s, "/panic", "gzip"); w.Code != 500 || w.Body.String() != "Server Error" {
+		t.Errorf("panic: got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestCompressSkipped(t *testing.T) {
+	s := compressServer()
+	for _, c := range []struct {
+		path, accept string
+		length       string
+	}{
+		{"/small", "gzip", "5"},
+		{"/png", "gzip", ""},
+		{"/list", "identity", ""},
+		{"/list", "", ""},
+	} {
+		w := serveEncoded(s, c.path, c.accept)
+		if enc := w.Header().Get("Content-Encoding"); enc != "" {
+			t.Errorf("%s with %q: Content-Encoding %q", c.path, c.accept, enc)
+		}
+		if c.length != "" && w.Header().Get("Content-Length") != c.length {
+			t.Errorf("%s: Content-Length %q, want %s", c.path, w.Header().Get("Content-Length"), c.length)
+		}
+	}
+}
+
+type upperWriter struct{ w io.Writer }
+
+func (u upperWriter) Write(b []byte) (int, error) {
+	return u.w.Write([]byte(strings.ToUpper(string(b))))
+}
+func (u upperWriter) Close() error { return nil }
+
+func TestRegisterEncoding(t *testing.T) {
+	RegisterEncoding("upper", func(w io.Writer, level int) (io.WriteCloser, error) {
+		return upperWriter{w}, nil
+	})
+	s := newTestServer()
+	s.Use(Compress(CompressOptions{Encodings: []string{"upper", "gzip"}, MinSize: 1}))
+	s.Get("/", func(ctx *Context) string { return "hello" })
+
+	w := serveEncoded(s, "/", "gzip, upper")
+	if w.Header().Get("Content-Encoding") != "upper" || w.Body.String() != "HELLO" {
+		t.Errorf("got %q %q", w.Header().Get("Content-Encoding"), w.Body.String())
+	}
+}
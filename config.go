@@ -86,6 +86,20 @@ func (cfg *Config) Int(key string) int {
 	return cfg.data.GetPath(k...).MustInt()
 }
 
+// Strings returns the string values for given key, which holds a list of
+// strings or a single one.
+// vals := conf.Strings('cookie.secret')
+func (cfg *Config) Strings(key string) []string {
+	val := cfg.data.GetPath(strings.Split(key, ".")...)
+	if s, err := val.String(); err == nil {
+		if s == "" {
+			return nil
+		}
+		return []string{s}
+	}
+	return val.MustStringArray()
+}
+
 // String returns the string value for given key.
 // cfg, err := conf.Set('redis.key', 'key123')
 func (cfg *Config) Set(key, val string) {
@@ -3,18 +3,13 @@
 package next
 
 import (
-	"bytes"
 	"crypto/hmac"
 	"crypto/sha1"
-	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"mime"
-	"net"
 	"net/http"
-	"strconv"
 	"strings"
-	"time"
 )
 
 // A Context object is created for every incoming HTTP request, and is
@@ -26,6 +21,77 @@ type Context struct {
 	Params  map[string]string
 	Server  *Server
 	http.ResponseWriter
+
+	writer     *responseWriter
+	reqID      string
+	route      *Route
+	pathParams []PathParam
+	// raw request body, read before routing unless the route streams it
+	body   []byte
+	stream bool
+
+	// files of a multipart request, see Files
+	uploads   []*UploadFile
+	uploadErr error
+	uploaded  bool
+
+	session *Session
+	csrf    *CSRFOptions
+	events  *EventStream
+	// a CORS policy was applied, see cors
+	corsDone bool
+
+	// middleware chain of the request, see Next
+	chain   []Middleware
+	index   int
+	aborted bool
+}
+
+// Next runs the remaining middleware of the chain and the route handler.
+// It is meant to be called from a middleware; the code after Next runs
+// once the handler has returned.
+func (ctx *Context) Next() {
+	for ctx.index < len(ctx.chain) && !ctx.aborted {
+		h := ctx.chain[ctx.index]
+		ctx.index++
+		h(ctx)
+	}
+}
+
+// PathParam returns the value of the named parameter of the matched route,
+// as in ctx.PathParam("id") for "/users/:id". Named groups of regular
+// expression routes, (?P<id>\d+), are found the same way.
+func (ctx *Context) PathParam(name string) string {
+	for _, p := range ctx.pathParams {
+		if p.Name == name {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// Body returns the raw body of the request. For a route set to Stream, it
+// is read on the first call; a body over the size limit of the route is
+// then reported as a 413 *HTTPError.
+func (ctx *Context) Body() ([]byte, error) {
+	if ctx.body == nil && ctx.stream {
+		body, err := ioutil.ReadAll(ctx.Request.Body)
+		if err != nil {
+			if isBodyTooLarge(err) {
+				he := NewHTTPError(413, "", "Request Entity Too Large")
+				he.Err = err
+				return nil, he
+			}
+			return nil, err
+		}
+		ctx.body = body
+	}
+	return ctx.body, nil
+}
+
+// Aborted reports whether Abort has been called for this request.
+func (ctx *Context) Aborted() bool {
+	return ctx.aborted
 }
 
 // WriteString writes string data into the response object.
@@ -61,15 +127,35 @@ func (ctx *Context) WriteJSON(code, msg string, data ...interface{}) {
 
 // Abort is a helper method that sends an HTTP header and an optional
 // body. It is useful for returning 4xx or 5xx errors.
-// Once it has been called, any return value from the handler will
-// not be written to the response.
+// Once it has been called, the rest of the middleware chain is skipped and
+// any return value from the handler will not be written to the response.
 func (ctx *Context) Abort(status int, body string) {
+	ctx.aborted = true
 	ctx.ResponseWriter.WriteHeader(status)
 	ctx.ResponseWriter.Write([]byte(body))
 }
 
+// serverError aborts the request with 500 Internal Server Error, unless
+// the response is already on its way. A response held back by Compress is
+// dropped for it.
+func (ctx *Context) serverError() {
+	if cw, ok := ctx.ResponseWriter.(*compressWriter); ok {
+		cw.discard()
+	}
+	if ctx.Written() {
+		ctx.aborted = true
+		return
+	}
+	ctx.Abort(500, "Server Error")
+}
+
 // Notmodified writes a 304 HTTP response
 func (ctx *Context) NotModified() {
+	// a 304 has no body to describe
+	h := ctx.ResponseWriter.Header()
+	delete(h, "Content-Type")
+	delete(h, "Content-Length")
+	delete(h, "Content-Encoding")
 	ctx.ResponseWriter.WriteHeader(304)
 }
 
@@ -135,65 +221,3 @@ func (ctx *Context) GetCookieSig(key string, val []byte, timestamp string) strin
 	return hex
 }
 
-func (ctx *Context) SetSecureCookie(name string, val string, age int64) {
-	//base64 encode the val
-	if ctx.Server.Config.String("cookie.secret") == "" {
-		ctx.Server.Logger.Println("Secret Key for secure cookies has not been set. Please assign a cookie secret to cookie.secret.")
-		return
-	}
-
-	var buf bytes.Buffer
-	encoder := base64.NewEncoder(base64.StdEncoding, &buf)
-	encoder.Write([]byte(val))
-	encoder.Close()
-	vs := buf.String()
-	vb := buf.Bytes()
-	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
-	sig := ctx.GetCookieSig(ctx.Server.Config.String("cookie.secret"), vb, timestamp)
-	cookie := strings.Join([]string{vs, timestamp, sig}, "|")
-	ctx.SetCookie(NewCookie(name, cookie, age))
-}
-
-func (ctx *Context) GetSecureCookie(name string) (string, bool) {
-	for _, cookie := range ctx.Request.Cookies() {
-		if cookie.Name != name {
-			continue
-		}
-
-		parts := strings.SplitN(cookie.Value, "|", 3)
-
-		val := parts[0]
-		timestamp := parts[1]
-		sig := parts[2]
-
-		if ctx.GetCookieSig(ctx.Server.Config.String("cookie.secret"), []byte(val), timestamp) != sig {
-			return "", false
-		}
-
-		ts, _ := strconv.ParseInt(timestamp, 0, 64)
-
-		if time.Now().Unix()-31*86400 > ts {
-			return "", false
-		}
-
-		buf := bytes.NewBufferString(val)
-		encoder := base64.NewDecoder(base64.StdEncoding, buf)
-
-		res, _ := ioutil.ReadAll(encoder)
-		return string(res), true
-	}
-	return "", false
-}
-
-func (ctx *Context) ClientIp() (string, error) {
-	ip, _, err := net.SplitHostPort(ctx.Request.RemoteAddr)
-	if err != nil {
-		return "", fmt.Errorf("userip: %q is not IP:port", ctx.Request.RemoteAddr)
-	}
-
-	userIP := net.ParseIP(ip)
-	if userIP == nil {
-		return "", fmt.Errorf("userip: %q is not IP:port", ctx.Request.RemoteAddr)
-	}
-	return userIP.String(), nil
-}
@@ -0,0 +1,215 @@
+package next
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors of ReadSecureCookie.
+var (
+	ErrNoSecret      = errors.New("next: cookie.secret is not set")
+	ErrInvalidCookie = errors.New("next: invalid secure cookie")
+	ErrExpiredCookie = errors.New("next: expired secure cookie")
+)
+
+// defaultCookieMaxAge is how long a secure cookie is valid when the
+// cookie.max_age setting is not set.
+const defaultCookieMaxAge = 31 * 86400
+
+// A secure cookie holds "v2|timestamp|value|signature". The value is the
+// base64 of the plain value, or of a nonce and the value sealed with
+// AES-256-GCM when the cookie.encrypt setting is on. The signature is an
+// HMAC-SHA256 of the name of the cookie, the timestamp and the value, so
+// that a cookie is not valid under another name.
+//
+// The keys derive from cookie.secret, which is a string or a list of
+// strings: cookies are made with the first one and read with any of them,
+// so a new secret is put first and the old one dropped once the cookies it
+// made have expired.
+const cookieV2 = "v2"
+
+// SetSecureCookie sets a cookie holding val that the client can not
+// forge, nor read if cookie.encrypt is on. Age is in seconds, see
+// NewCookie. Nothing is set when cookie.secret is not.
+func (ctx *Context) SetSecureCookie(name string, val string, age int64) {
+	if cookie := ctx.signCookie(name, val); cookie != "" {
+		ctx.SetCookie(NewCookie(name, cookie, age))
+	}
+}
+
+// signCookie returns the value of the secure cookie name holding val, or
+// "" when no secret is set.
+func (ctx *Context) signCookie(name, val string) string {
+	secrets := ctx.Server.Config.Strings("cookie.secret")
+	if len(secrets) == 0 {
+		ctx.Server.Logger.Println("Secret Key for secure cookies has not been set. Please assign a cookie secret to cookie.secret.")
+		return ""
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	value := []byte(val)
+	if ctx.Server.Config.Bool("cookie.encrypt") {
+		var err error
+		if value, err = sealCookie(secrets[0], name, timestamp, value); err != nil {
+			ctx.Server.Logger.Println("Secure cookie encryption failed", err)
+			return ""
+		}
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(value)
+	sig := cookieSig(secrets[0], name, timestamp, encoded)
+	return strings.Join([]string{cookieV2, timestamp, encoded, sig}, "|")
+}
+
+// GetSecureCookie returns the value of a cookie set by SetSecureCookie,
+// and false when it is missing or not valid, see ReadSecureCookie.
+func (ctx *Context) GetSecureCookie(name string) (string, bool) {
+	val, err := ctx.ReadSecureCookie(name)
+	return val, err == nil
+}
+
+// ReadSecureCookie returns the value of a cookie set by SetSecureCookie.
+// It fails with http.ErrNoCookie when the cookie is missing,
+// ErrInvalidCookie when it was not made with one of the secrets of
+// cookie.secret, and ErrExpiredCookie when it is older than cookie.max_age
+// seconds (31 days by default). Cookies made before the current format,
+// signed with HMAC-SHA1, are still read.
+func (ctx *Context) ReadSecureCookie(name string) (string, error) {
+	cookie, err := ctx.Request.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	secrets := ctx.Server.Config.Strings("cookie.secret")
+	if len(secrets) == 0 {
+		return "", ErrNoSecret
+	}
+
+	var val string
+	var ts int64
+	if strings.HasPrefix(cookie.Value, cookieV2+"|") {
+		val, ts, err = ctx.readCookieV2(secrets, name, cookie.Value)
+	} else {
+		val, ts, err = ctx.readCookieV1(secrets, cookie.Value)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	maxAge := int64(ctx.Server.Config.Int("cookie.max_age"))
+	if maxAge <= 0 {
+		maxAge = defaultCookieMaxAge
+	}
+	if time.Now().Unix()-maxAge > ts {
+		return "", ErrExpiredCookie
+	}
+	return val, nil
+}
+
+func (ctx *Context) readCookieV2(secrets []string, name, cookie string) (string, int64, error) {
+	parts := strings.Split(cookie, "|")
+	if len(parts) != 4 {
+		return "", 0, ErrInvalidCookie
+	}
+	timestamp, encoded, sig := parts[1], parts[2], parts[3]
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", 0, ErrInvalidCookie
+	}
+
+	for _, secret := range secrets {
+		if !hmac.Equal([]byte(sig), []byte(cookieSig(secret, name, timestamp, encoded))) {
+			continue
+		}
+		value, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", 0, ErrInvalidCookie
+		}
+		if ctx.Server.Config.Bool("cookie.encrypt") {
+			if value, err = openCookie(secret, name, timestamp, value); err != nil {
+				return "", 0, ErrInvalidCookie
+			}
+		}
+		return string(value), ts, nil
+	}
+	return "", 0, ErrInvalidCookie
+}
+
+// readCookieV1 reads the "base64|timestamp|hex HMAC-SHA1" cookies made
+// before the current format.
+func (ctx *Context) readCookieV1(secrets []string, cookie string) (string, int64, error) {
+	parts := strings.Split(cookie, "|")
+	if len(parts) != 3 {
+		return "", 0, ErrInvalidCookie
+	}
+	val, timestamp, sig := parts[0], parts[1], parts[2]
+	ts, err := strconv.ParseInt(timestamp, 0, 64)
+	if err != nil {
+		return "", 0, ErrInvalidCookie
+	}
+
+	for _, secret := range secrets {
+		if !hmac.Equal([]byte(sig), []byte(ctx.GetCookieSig(secret, []byte(val), timestamp))) {
+			continue
+		}
+		res, err := base64.StdEncoding.DecodeString(val)
+		if err != nil {
+			return "", 0, ErrInvalidCookie
+		}
+		return string(res), ts, nil
+	}
+	return "", 0, ErrInvalidCookie
+}
+
+// cookieKey derives the key for purpose from secret.
+func cookieKey(secret, purpose string) []byte {
+	hm := hmac.New(sha256.New, []byte(secret))
+	hm.Write([]byte("next cookie " + purpose))
+	return hm.Sum(nil)
+}
+
+func cookieSig(secret, name, timestamp, value string) string {
+	hm := hmac.New(sha256.New, cookieKey(secret, "signature"))
+	io.WriteString(hm, cookieV2+"|"+name+"|"+timestamp+"|"+value)
+	return base64.RawURLEncoding.EncodeToString(hm.Sum(nil))
+}
+
+func cookieAEAD(secret string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(cookieKey(secret, "encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealCookie encrypts value, bound to the name and timestamp of the cookie.
+func sealCookie(secret, name, timestamp string, value []byte) ([]byte, error) {
+	aead, err := cookieAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, value, []byte(name+"|"+timestamp)), nil
+}
+
+func openCookie(secret, name, timestamp string, sealed []byte) ([]byte, error) {
+	aead, err := cookieAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidCookie
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, []byte(name+"|"+timestamp))
+}
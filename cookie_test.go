@@ -0,0 +1,89 @@
+package next
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cookieContext returns a context of a server with config, holding the
+// cookies of the request.
+func cookieContext(config string, cookies ...*http.Cookie) *Context {
+	s := newTestServer()
+	s.Config.Read([]byte(config))
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	return &Context{Request: req, Server: s, ResponseWriter: httptest.NewRecorder()}
+}
+
+func setCookie(config, name, val string) *http.Cookie {
+	ctx := cookieContext(config)
+	ctx.SetSecureCookie(name, val, 0)
+	return ctx.ResponseWriter.(*httptest.ResponseRecorder).Result().Cookies()[0]
+}
+
+func TestSecureCookie(t *testing.T) {
+	for _, config := range []string{
+		`{"cookie": {"secret": "s1"}}`,
+		`{"cookie": {"secret": "s1", "encrypt": true}}`,
+	} {
+		c := setCookie(config, "user", "fred|42")
+		if strings.Contains(config, "encrypt") && strings.Contains(c.Value, base64.RawURLEncoding.EncodeToString([]byte("fred"))) {
+			t.Errorf("%s: value is readable in %q", config, c.Value)
+		}
+		if val, err := cookieContext(config, c).ReadSecureCookie("user"); err != nil || val != "fred|42" {
+			t.Errorf("%s: read %q, %v", config, val, err)
+		}
+
+		forged := &http.Cookie{Name: "user", Value: c.Value[:len(c.Value)-2] + "xx"}
+		if _, err := cookieContext(config, forged).ReadSecureCookie("user"); err != ErrInvalidCookie {
+			t.Errorf("%s: forged cookie: %v", config, err)
+		}
+		renamed := &http.Cookie{Name: "admin", Value: c.Value}
+		if _, err := cookieContext(config, renamed).ReadSecureCookie("admin"); err != ErrInvalidCookie {
+			t.Errorf("%s: renamed cookie: %v", config, err)
+		}
+	}
+}
+
+func TestSecureCookieRotation(t *testing.T) {
+	old := setCookie(`{"cookie": {"secret": "old"}}`, "user", "fred")
+
+	ctx := cookieContext(`{"cookie": {"secret": ["new", "old"]}}`, old)
+	if val, err := ctx.ReadSecureCookie("user"); err != nil || val != "fred" {
+		t.Errorf("read %q, %v", val, err)
+	}
+	ctx = cookieContext(`{"cookie": {"secret": ["new"]}}`, old)
+	if _, err := ctx.ReadSecureCookie("user"); err != ErrInvalidCookie {
+		t.Errorf("dropped secret: %v", err)
+	}
+}
+
+func TestSecureCookieV1(t *testing.T) {
+	config := `{"cookie": {"secret": "s1", "max_age": 60}}`
+	v1 := func(val string, ts int64) *http.Cookie {
+		vs := base64.StdEncoding.EncodeToString([]byte(val))
+		timestamp := strconv.FormatInt(ts, 10)
+		sig := (&Context{}).GetCookieSig("s1", []byte(vs), timestamp)
+		return &http.Cookie{Name: "user", Value: vs + "|" + timestamp + "|" + sig}
+	}
+
+	if val, err := cookieContext(config, v1("fred", time.Now().Unix())).ReadSecureCookie("user"); err != nil || val != "fred" {
+		t.Errorf("read %q, %v", val, err)
+	}
+	if _, err := cookieContext(config, v1("fred", time.Now().Unix()-120)).ReadSecureCookie("user"); err != ErrExpiredCookie {
+		t.Errorf("expired cookie: %v", err)
+	}
+	for _, value := range []string{"", "a", "a|b", "v2|1|a", "a|1|b|c|d"} {
+		ctx := cookieContext(config, &http.Cookie{Name: "user", Value: value})
+		if _, err := ctx.ReadSecureCookie("user"); err != ErrInvalidCookie {
+			t.Errorf("%q: %v", value, err)
+		}
+	}
+}
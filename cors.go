@@ -0,0 +1,208 @@
+package next
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CORSOptions is a policy of cross-origin requests.
+type CORSOptions struct {
+	// Origins lists the origins allowed to call: "*" for any,
+	// "https://*.example.com" with a wildcard, or a regular expression
+	// starting with "^".
+	Origins []string
+	// Methods lists the methods allowed in preflight requests, the methods
+	// of the route by default.
+	Methods []string
+	// Headers lists the request headers allowed in preflight requests, the
+	// ones asked for by default.
+	Headers []string
+	// Expose lists the response headers scripts may read.
+	Expose []string
+	// Credentials lets cookies and authorization headers through.
+	Credentials bool
+	// MaxAge is how long the answer of a preflight request may be cached,
+	// in seconds.
+	MaxAge int
+}
+
+type corsPolicy struct {
+	CORSOptions
+	anyOrigin bool
+	origins   []*regexp.Regexp
+}
+
+// newCORSPolicy compiles the origins of o. It fails on a bad regular
+// expression.
+func newCORSPolicy(o CORSOptions) (*corsPolicy, error) {
+	p := &corsPolicy{CORSOptions: o}
+	for _, origin := range o.Origins {
+		expr := origin
+		switch {
+		case origin == "*":
+			p.anyOrigin = true
+			continue
+		case !strings.HasPrefix(origin, "^"):
+			expr = "^" + strings.Replace(regexp.QuoteMeta(origin), `\*`, `[^/]*`, -1) + "$"
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("next: CORS origin %q: %v", origin, err)
+		}
+		p.origins = append(p.origins, re)
+	}
+	return p, nil
+}
+
+func mustCORSPolicy(o CORSOptions) *corsPolicy {
+	p, err := newCORSPolicy(o)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	for _, re := range p.origins {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsOptions reads a policy from the cors section of cfg:
+//
+//	"cors": {
+//		"origins": ["https://*.example.com"],
+//		"methods": ["GET", "POST"],
+//		"headers": ["Content-Type", "Authorization"],
+//		"expose": ["X-Request-Id"],
+//		"credentials": true,
+//		"max_age": 600
+//	}
+func corsOptions(cfg *Config) CORSOptions {
+	return CORSOptions{
+		Origins:     cfg.Strings("cors.origins"),
+		Methods:     cfg.Strings("cors.methods"),
+		Headers:     cfg.Strings("cors.headers"),
+		Expose:      cfg.Strings("cors.expose"),
+		Credentials: cfg.Bool("cors.credentials"),
+		MaxAge:      cfg.Int("cors.max_age"),
+	}
+}
+
+// CORS returns middleware applying a policy of cross-origin requests,
+// the cors section of the server config when none is given. Used on the
+// server, it answers preflight requests itself, so that no OPTIONS route
+// is needed; a route may have a policy of its own, see Route.CORS.
+//
+// CORS panics on an origin that is not a valid regular expression. The
+// cors section is read on the first request, which fails the same way
+// with a bad origin, and so do the requests after it.
+func CORS(opts ...CORSOptions) Middleware {
+	if len(opts) > 0 {
+		policy := mustCORSPolicy(opts[0])
+		return func(ctx *Context) {
+			ctx.cors(policy)
+		}
+	}
+
+	var once sync.Once
+	var policy *corsPolicy
+	var err error
+	return func(ctx *Context) {
+		once.Do(func() {
+			policy, err = newCORSPolicy(corsOptions(ctx.Server.Config))
+		})
+		if err != nil {
+			panic(err)
+		}
+		ctx.cors(policy)
+	}
+}
+
+// CORS sets the policy of cross-origin requests of route r, over the one
+// of the CORS middleware of the server. It applies without the
+// middleware too, preflight requests included. CORS panics on an origin
+// that is not a valid regular expression.
+func (r *Route) CORS(opts CORSOptions) *Route {
+	r.conf.cors = mustCORSPolicy(opts)
+	return r
+}
+
+// cors applies policy p to the request, or the one of its route if it has
+// one, once per request. p is nil when only a route policy applies.
+func (ctx *Context) cors(p *corsPolicy) {
+	if ctx.corsDone {
+		return
+	}
+	req := ctx.Request
+	origin := req.Header.Get("Origin")
+	reqMethod := req.Header.Get("Access-Control-Request-Method")
+	preflight := origin != "" && req.Method == "OPTIONS" && reqMethod != ""
+	route := ctx.route
+	if preflight {
+		route, _ = ctx.Server.routes.Match(req.URL.Path, reqMethod)
+	}
+	if route != nil && route.conf.cors != nil {
+		p = route.conf.cors
+	}
+	if p == nil {
+		return
+	}
+	ctx.corsDone = true
+
+	// a policy answering with the origin varies with it, Origin or not,
+	// so that a cache does not give a response to the wrong callers
+	if !p.anyOrigin || p.Credentials {
+		ctx.SetHeader("Vary", "Origin", false)
+	}
+	if origin == "" {
+		return
+	}
+
+	if !p.allowOrigin(origin) {
+		return
+	}
+	if p.anyOrigin && !p.Credentials {
+		ctx.SetHeader("Access-Control-Allow-Origin", "*", true)
+	} else {
+		ctx.SetHeader("Access-Control-Allow-Origin", origin, true)
+	}
+	if p.Credentials {
+		ctx.SetHeader("Access-Control-Allow-Credentials", "true", true)
+	}
+
+	if !preflight {
+		if len(p.Expose) > 0 {
+			ctx.SetHeader("Access-Control-Expose-Headers", strings.Join(p.Expose, ", "), true)
+		}
+		return
+	}
+
+	ctx.SetHeader("Vary", "Access-Control-Request-Method", false)
+	ctx.SetHeader("Vary", "Access-Control-Request-Headers", false)
+	methods := p.Methods
+	if len(methods) == 0 {
+		methods = ctx.Server.routes.Allowed(req.URL.Path)
+	}
+	if len(methods) > 0 {
+		ctx.SetHeader("Access-Control-Allow-Methods", strings.Join(methods, ", "), true)
+	}
+	if len(p.Headers) > 0 {
+		ctx.SetHeader("Access-Control-Allow-Headers", strings.Join(p.Headers, ", "), true)
+	} else if h := req.Header.Get("Access-Control-Request-Headers"); h != "" {
+		ctx.SetHeader("Access-Control-Allow-Headers", h, true)
+	}
+	if p.MaxAge > 0 {
+		ctx.SetHeader("Access-Control-Max-Age", strconv.Itoa(p.MaxAge), true)
+	}
+	ctx.Abort(204, "")
+}
@@ -0,0 +1,101 @@
+package next
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func corsRequest(s *Server, method, path, origin string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Origin", origin)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORS(t *testing.T) {
+	s := newTestServer()
+	s.Config.Read([]byte(`{"cors": {"origins": ["https://*.example.com", "^http://localhost:\\d+$"],
+		"credentials": true, "max_age": 600, "expose": ["X-Total"]}}`))
+	s.Use(CORS())
+	s.Get("/users", func() string { return "users" })
+	s.Post("/users", func() string { return "created" })
+	s.Get("/public", func() string { return "public" }).CORS(CORSOptions{Origins: []string{"*"}})
+
+	w := corsRequest(s, "GET", "/users", "https://app.example.com")
+	h := w.Header()
+	if h.Get("Access-Control-Allow-Origin") != "https://app.example.com" || h.Get("Access-Control-Allow-Credentials") != "true" ||
+		h.Get("Access-Control-Expose-Headers") != "X-Total" || w.Body.String() != "users" {
+		t.Errorf("simple request: %v %s", h, w.Body.String())
+	}
+
+	// a cache must not give the response without Origin to other callers
+	if w := corsRequest(s, "GET", "/users", ""); w.Header().Get("Vary") != "Origin" {
+		t.Errorf("no Origin: Vary %q", w.Header().Get("Vary"))
+	}
+	if w := corsRequest(s, "GET", "/public", ""); w.Header().Get("Vary") != "" {
+		t.Errorf("any origin: Vary %q", w.Header().Get("Vary"))
+	}
+
+	for _, origin := range []string{"https://evil.com", "https://a.b.example.com.evil.com", "http://localhost:80x"} {
+		if w := corsRequest(s, "GET", "/users", origin); w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%s was allowed", origin)
+		}
+	}
+
+	w = corsRequest(s, "OPTIONS", "/users", "http://localhost:3000",
+		"Access-Control-Request-Method", "POST", "Access-Control-Request-Headers", "Content-Type")
+	h = w.Header()
+	if w.Code != 204 || h.Get("Access-Control-Allow-Methods") != "GET, HEAD, OPTIONS, POST" ||
+		h.Get("Access-Control-Allow-Headers") != "Content-Type" || h.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("preflight: %d %v", w.Code, h)
+	}
+
+	w = corsRequest(s, "OPTIONS", "/public", "https://evil.com", "Access-Control-Request-Method", "GET")
+	if w.Code != 204 || w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("route policy: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestRouteCORSAlone(t *testing.T) {
+	s := newTestServer()
+	s.Get("/public", func() string { return "public" }).CORS(CORSOptions{Origins: []string{"https://*.example.com"}})
+	s.Get("/private", func() string { return "private" })
+
+	w := corsRequest(s, "GET", "/public", "https://app.example.com")
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || w.Header().Get("Vary") != "Origin" {
+		t.Errorf("route policy without middleware: %v", w.Header())
+	}
+	w = corsRequest(s, "OPTIONS", "/public", "https://app.example.com", "Access-Control-Request-Method", "GET")
+	if w.Code != 204 || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("preflight without middleware: %d %v", w.Code, w.Header())
+	}
+	if w := corsRequest(s, "GET", "/private", "https://app.example.com"); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("route without policy: %v", w.Header())
+	}
+}
+
+func TestCORSBadOrigin(t *testing.T) {
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("no panic on a bad origin")
+			}
+		}()
+		CORS(CORSOptions{Origins: []string{"^(bad"}})
+	}()
+
+	s := newTestServer()
+	s.Config.Read([]byte(`{"cors": {"origins": ["^(bad"]}}`))
+	s.Use(CORS())
+	s.Get("/", func() string { return "ok" })
+	// the first request and the ones after it
+	for i := 0; i < 2; i++ {
+		if w := corsRequest(s, "GET", "/", "https://a.com"); w.Code != 500 {
+			t.Errorf("request %d: got %d, want 500", i, w.Code)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package next
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// CSRFOptions configures the CSRF middleware.
+type CSRFOptions struct {
+	// DoubleSubmit keeps the token in a cookie the client sends back along
+	// with the token, instead of in the session. The cookie is signed with
+	// cookie.secret, so that a cookie planted by another site, or another
+	// subdomain, is not taken; scripts get the token from the page.
+	DoubleSubmit bool
+	// Cookie names the cookie of DoubleSubmit mode, "next_csrf" by default.
+	Cookie string
+	// Header and Field name where the token of a request is looked for:
+	// "X-CSRF-Token" and "csrf_token" by default.
+	Header string
+	Field  string
+	// Exempt lists the paths that are not checked. A path ending with "*"
+	// stands for all the paths starting with the rest of it.
+	Exempt []string
+}
+
+const csrfSessionKey = "_csrf"
+
+func (o CSRFOptions) withDefaults() CSRFOptions {
+	if o.Cookie == "" {
+		o.Cookie = "next_csrf"
+	}
+	if o.Header == "" {
+		o.Header = "X-CSRF-Token"
+	}
+	if o.Field == "" {
+		o.Field = "csrf_token"
+	}
+	return o
+}
+
+// CSRF returns middleware that rejects the requests of unsafe methods,
+// the ones other than GET, HEAD, OPTIONS and TRACE, that do not carry the
+// CSRF token of the client, with 403 Forbidden. Pages put the token in
+// their forms with ctx.CSRFToken:
+//
+//	<input type="hidden" name="csrf_token" value="{{.csrf}}">
+//
+// and scripts send it in the X-CSRF-Token header. By default the token is
+// kept in the session of the client; see CSRFOptions for the
+// double-submit cookie mode. The middleware protects the routes it is
+// used on: the server with s.Use, or a group with g.Use. Routes set with
+// Route.CSRFExempt and the Exempt paths are let through.
+//
+// A token sent in a form field, rather than the header, is read from the
+// body before the handler runs. On a route set to Stream, this parses the
+// form, a multipart one with ctx.Files: the handler then finds the fields
+// in ctx.Request.PostForm and the files with ctx.Files, not in the body.
+func CSRF(opts ...CSRFOptions) Middleware {
+	var o CSRFOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	o = o.withDefaults()
+
+	return func(ctx *Context) {
+		ctx.csrf = &o
+		switch ctx.Request.Method {
+		case "GET", "HEAD", "OPTIONS", "TRACE":
+			return
+		}
+		if ctx.route != nil && ctx.route.conf.csrfExempt || o.exempt(ctx.Request.URL.Path) {
+			return
+		}
+
+		want := ctx.storedCSRFToken()
+		got := ctx.Request.Header.Get(o.Header)
+		if got == "" {
+			got = ctx.csrfField(o.Field)
+		}
+		if want == "" || subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+			ctx.Abort(403, "Forbidden: invalid CSRF token")
+		}
+	}
+}
+
+func (o *CSRFOptions) exempt(path string) bool {
+	for _, p := range o.Exempt {
+		if p == path || strings.HasSuffix(p, "*") && strings.HasPrefix(path, p[:len(p)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// csrfField returns the token sent in a field of the form in the body.
+// The query string is not looked at: a token in a URL leaks through logs
+// and Referer headers.
+func (ctx *Context) csrfField(field string) string {
+	if v := ctx.Request.PostForm.Get(field); v != "" {
+		return v
+	}
+	if strings.HasPrefix(ctx.Request.Header.Get("Content-Type"), "multipart/form-data") {
+		// Files adds the fields of the form to PostForm
+		ctx.Files()
+	} else if ctx.stream && ctx.Request.PostForm == nil {
+		ctx.Request.ParseForm()
+	}
+	return ctx.Request.PostForm.Get(field)
+}
+
+// CSRFToken returns the CSRF token of the client, making one when it has
+// none yet.
+func (ctx *Context) CSRFToken() string {
+	if token := ctx.storedCSRFToken(); token != "" {
+		return token
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	o := ctx.csrfOptions()
+	if o.DoubleSubmit {
+		value := ctx.signCookie(o.Cookie, token)
+		if value == "" {
+			panic("next: CSRF double-submit cookies need cookie.secret to be set")
+		}
+		ctx.SetCookie(&http.Cookie{Name: o.Cookie, Value: value, Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode})
+		// read back by storedCSRFToken, in place of a bad one sent
+		cookies := ctx.Request.Cookies()
+		ctx.Request.Header.Del("Cookie")
+		for _, c := range cookies {
+			if c.Name != o.Cookie {
+				ctx.Request.AddCookie(c)
+			}
+		}
+		ctx.Request.AddCookie(&http.Cookie{Name: o.Cookie, Value: value})
+	} else {
+		ctx.Session().Set(csrfSessionKey, token)
+	}
+	return token
+}
+
+func (ctx *Context) csrfOptions() *CSRFOptions {
+	if ctx.csrf == nil {
+		o := CSRFOptions{}.withDefaults()
+		ctx.csrf = &o
+	}
+	return ctx.csrf
+}
+
+func (ctx *Context) storedCSRFToken() string {
+	o := ctx.csrfOptions()
+	if o.DoubleSubmit {
+		token, _ := ctx.GetSecureCookie(o.Cookie)
+		return token
+	}
+	return ctx.Session().String(csrfSessionKey)
+}
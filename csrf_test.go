@@ -0,0 +1,113 @@
+package next
+
+import (
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// post sends a form to path with the cookies of jar, and keeps the ones
+// set.
+func post(s *Server, jar map[string]*http.Cookie, path, form string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	for _, c := range jar {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	for _, c := range w.Result().Cookies() {
+		jar[c.Name] = c
+	}
+	return w
+}
+
+func csrfServer(opts ...CSRFOptions) *Server {
+	s := newTestServer()
+	s.Config.Set("cookie.secret", "secret")
+	s.Use(CSRF(opts...))
+	s.Get("/form", func(ctx *Context) string {
+		return ctx.CSRFToken()
+	})
+	s.Post("/save", func() string { return "saved" })
+	s.Post("/hook", func() string { return "hooked" }).CSRFExempt()
+	s.Post("/api/v1/ping", func() string { return "pong" })
+	s.Post("/stream", func(ctx *Context) string {
+		return ctx.Request.PostForm.Get("name")
+	}).Stream()
+	return s
+}
+
+func TestCSRF(t *testing.T) {
+	for _, opts := range []CSRFOptions{{}, {DoubleSubmit: true}} {
+		s := csrfServer(opts)
+		jar := map[string]*http.Cookie{}
+
+		if w := post(s, jar, "/save", ""); w.Code != 403 {
+			t.Errorf("%+v: no token: got %d", opts, w.Code)
+		}
+		token := get(s, jar, "/form").Body.String()
+		if again := get(s, jar, "/form").Body.String(); again != token {
+			t.Errorf("%+v: token changed from %q to %q", opts, token, again)
+		}
+
+		if w := post(s, jar, "/save", "csrf_token=bad"); w.Code != 403 {
+			t.Errorf("%+v: bad token: got %d", opts, w.Code)
+		}
+		if w := post(s, jar, "/save", "csrf_token="+token); w.Body.String() != "saved" {
+			t.Errorf("%+v: form token: got %d %s", opts, w.Code, w.Body.String())
+		}
+		if w := post(s, jar, "/save", "", "X-CSRF-Token", token); w.Body.String() != "saved" {
+			t.Errorf("%+v: header token: got %d %s", opts, w.Code, w.Body.String())
+		}
+		// a token in the URL leaks, it is not taken
+		if w := post(s, jar, "/save?csrf_token="+token, ""); w.Code != 403 {
+			t.Errorf("%+v: query token: got %d", opts, w.Code)
+		}
+		var form strings.Builder
+		mw := multipart.NewWriter(&form)
+		mw.WriteField("csrf_token", token)
+		mw.Close()
+		if w := post(s, jar, "/save", form.String(), "Content-Type", mw.FormDataContentType()); w.Body.String() != "saved" {
+			t.Errorf("%+v: multipart token: got %d %s", opts, w.Code, w.Body.String())
+		}
+		if w := post(s, jar, "/stream", "name=fred&csrf_token="+token); w.Body.String() != "fred" {
+			t.Errorf("%+v: stream route: got %d %s", opts, w.Code, w.Body.String())
+		}
+		// another client has no token of its own
+		if w := post(s, map[string]*http.Cookie{}, "/save", "csrf_token="+token); w.Code != 403 {
+			t.Errorf("%+v: stolen token: got %d", opts, w.Code)
+		}
+	}
+}
+
+func TestCSRFForgedCookie(t *testing.T) {
+	s := csrfServer(CSRFOptions{DoubleSubmit: true})
+	jar := map[string]*http.Cookie{"next_csrf": {Name: "next_csrf", Value: "planted"}}
+	if w := post(s, jar, "/save", "csrf_token=planted"); w.Code != 403 {
+		t.Errorf("planted cookie: got %d", w.Code)
+	}
+	token := get(s, jar, "/form").Body.String()
+	if token == "planted" || jar["next_csrf"].Value == token {
+		t.Errorf("cookie %q holds the plain token", jar["next_csrf"].Value)
+	}
+	if w := post(s, jar, "/save", "csrf_token="+token); w.Body.String() != "saved" {
+		t.Errorf("signed cookie: got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCSRFExempt(t *testing.T) {
+	s := csrfServer(CSRFOptions{Exempt: []string{"/api/*"}})
+	jar := map[string]*http.Cookie{}
+	if w := post(s, jar, "/hook", ""); w.Body.String() != "hooked" {
+		t.Errorf("exempt route: got %d", w.Code)
+	}
+	if w := post(s, jar, "/api/v1/ping", ""); w.Body.String() != "pong" {
+		t.Errorf("exempt path: got %d", w.Code)
+	}
+}
@@ -3,6 +3,7 @@ package next
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -20,6 +21,7 @@ type Duo struct {
 	Logger     *log.Logger
 	routes     *Routes
 	middleware []reflect.Value
+	track      connTracker
 }
 
 const (
@@ -148,23 +150,20 @@ func (t *Duo) handler(conn *net.TCPConn, body []byte) {
 	tm := time.Now().UTC()
 	defer t.logRequest(ctx, tm)
 
-	route := t.routes.Match(requestPath, "VIA")
+	route, params := t.routes.Match(requestPath, "VIA")
 	if route == nil {
 		//ctx.WriteJSON("404", "request method not found")
 		return
 	}
 
-	cr := route.cr
-
 	var args []reflect.Value
 	handlerType := route.handler.Type()
 	if requiresDuoContext(handlerType) {
 		args = append(args, reflect.ValueOf(&ctx))
 	}
 
-	match := cr.FindStringSubmatch(requestPath)
-	for _, arg := range match[1:] {
-		args = append(args, reflect.ValueOf(arg))
+	for _, param := range params {
+		args = append(args, reflect.ValueOf(param.Value))
 	}
 
 	t.safelyCall(route.handler, args)
@@ -177,21 +176,24 @@ func (t *Duo) Fd(conn *net.TCPConn) string {
 
 func (t *Duo) Pipe(conn *net.TCPConn) {
 	fd := t.Fd(conn)
+
+	// Save in map
+	if !t.track.add(t.Conn, fd, conn) {
+		conn.Close()
+		return
+	}
 	defer func() {
 		t.Logger.Printf("disconnected: %s\n", fd)
 		conn.Close()
-		delete(t.Conn, fd)
+		t.track.remove(t.Conn, fd)
 	}()
 
-	// Save in map
-	t.Conn[fd] = conn
-
 	// Read data
 	reader := bufio.NewReader(conn)
 	for {
 		body, err := t.Unpack(reader)
 		if err != nil {
-			if err == io.EOF {
+			if err == io.EOF || t.track.isClosing() {
 				return
 			}
 			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
@@ -206,7 +208,13 @@ func (t *Duo) Pipe(conn *net.TCPConn) {
 			continue
 		}
 
+		if !t.track.begin(fd) {
+			return
+		}
 		t.handler(conn, body)
+		if !t.track.end(fd) {
+			return
+		}
 		reader.Reset(conn)
 	}
 }
@@ -215,6 +223,9 @@ func (t *Duo) Run(addr string) {
 	tcpAddr, _ := net.ResolveTCPAddr("tcp", addr)
 	tcpListener, _ := net.ListenTCP("tcp", tcpAddr)
 	defer tcpListener.Close()
+	if !t.track.listen(tcpListener) {
+		return
+	}
 
 	t.Logger.Printf("next duo serving %s\n", addr)
 
@@ -231,6 +242,9 @@ func (t *Duo) Run(addr string) {
 	for {
 		tcpConn, err := tcpListener.AcceptTCP()
 		if err != nil {
+			if t.track.isClosing() {
+				return
+			}
 			continue
 		}
 
@@ -239,6 +253,14 @@ func (t *Duo) Run(addr string) {
 	}
 }
 
+// Shutdown stops duo gracefully. It stops accepting connections, closes
+// the idle ones and lets the handlers in progress finish until ctx is
+// done. Connections still busy then are closed, and reported in a
+// *ShutdownError.
+func (t *Duo) Shutdown(ctx context.Context) error {
+	return t.track.shutdown(ctx, t.Conn)
+}
+
 // Post adds a handler for the 'Via' TCP method for tcp.
 func (t *Duo) Middleware(handler interface{}) {
 	switch handler.(type) {
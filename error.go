@@ -0,0 +1,79 @@
+package next
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// HTTPError is an error carrying the HTTP status of the response, and the
+// code and message of its JSON body. A handler returns one to answer with
+// something else than 500 Server Error:
+//
+//	if user == nil {
+//		return nil, next.NewHTTPError(404, "user_not_found", "no such user")
+//	}
+type HTTPError struct {
+	Status int
+	Code   string
+	Msg    string
+	// Err is the underlying error, if any. It is logged, not sent.
+	Err error
+}
+
+// NewHTTPError returns an HTTPError. The code defaults to the status.
+func NewHTTPError(status int, code, msg string) *HTTPError {
+	if code == "" {
+		code = strconv.Itoa(status)
+	}
+	return &HTTPError{Status: status, Code: code, Msg: msg}
+}
+
+func (e *HTTPError) Error() string {
+	if e.Err != nil {
+		return e.Msg + ": " + e.Err.Error()
+	}
+	return e.Msg
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorHandler writes the response for an error returned by a handler.
+type ErrorHandler func(ctx *Context, err error)
+
+// DefaultErrorHandler is the ErrorHandler of a new Server. It answers an
+// *HTTPError with its status, code and message through WriteJSON,
+// ValidationErrors with 422 and the list of fields, context.DeadlineExceeded
+// with 504 Gateway Timeout, and any other error with 500 Server Error,
+// logging it. Nothing is answered to a client that left.
+func DefaultErrorHandler(ctx *Context, err error) {
+	if errors.Is(err, context.Canceled) && ctx.Context().Err() != nil {
+		// the client left: nobody to answer
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = &HTTPError{Status: 504, Code: "504", Msg: "Gateway Timeout", Err: err}
+	}
+
+	var verrs ValidationErrors
+	if errors.As(err, &verrs) {
+		ctx.ContentType("json")
+		ctx.ResponseWriter.WriteHeader(422)
+		ctx.WriteJSON("422", "validation failed", verrs)
+		return
+	}
+
+	var he *HTTPError
+	if !errors.As(err, &he) {
+		ctx.Server.Logger.Println("Handler returned error", err)
+		he = NewHTTPError(500, "", "Server Error")
+	} else if he.Err != nil {
+		ctx.Server.Logger.Println("Handler returned error", err)
+	}
+
+	ctx.ContentType("json")
+	ctx.ResponseWriter.WriteHeader(he.Status)
+	ctx.WriteJSON(he.Code, he.Msg)
+}
@@ -0,0 +1,139 @@
+package next
+
+import (
+	"net/http"
+)
+
+// A Group is a set of routes sharing a path prefix and middleware. The
+// routes of a group made by Server.Group are registered with the server as
+// they are added; a group made by NewGroup stands alone until it is
+// mounted, which makes it possible to declare a set of routes once and
+// mount it on several servers or under several prefixes.
+//
+//	admin := s.Group("/api/v1/admin", auth)
+//	admin.Get("/users", listUsers)
+//	admin.Group("/stats").Get("/daily", dailyStats)
+type Group struct {
+	prefix     string
+	middleware []Middleware
+	server     *Server
+	routes     []*Route
+	groups     []*Group
+	// groups this one is mounted on
+	mounts []*Group
+}
+
+// NewGroup returns a group that is not attached to any server yet.
+func NewGroup(prefix string, middleware ...Middleware) *Group {
+	return &Group{prefix: prefix, middleware: middleware}
+}
+
+// Group returns a group of routes of server s under prefix, which run
+// middleware after the global middleware of s.
+func (s *Server) Group(prefix string, middleware ...Middleware) *Group {
+	return &Group{prefix: prefix, middleware: middleware, server: s}
+}
+
+// Mount registers the routes of group g in server s under prefix.
+func (s *Server) Mount(prefix string, g *Group, middleware ...Middleware) {
+	s.Group(prefix, middleware...).Mount("", g)
+}
+
+// Group returns a group nested in g, whose prefix and middleware follow
+// the ones of g.
+func (g *Group) Group(prefix string, middleware ...Middleware) *Group {
+	child := NewGroup(prefix, middleware...)
+	g.Mount("", child)
+	return child
+}
+
+// Mount registers the routes of child under prefix in g. The routes added
+// to child later on are registered too.
+func (g *Group) Mount(prefix string, child *Group) {
+	target := g
+	if prefix != "" {
+		target = g.Group(prefix)
+	}
+	child.mounts = append(child.mounts, target)
+	target.groups = append(target.groups, child)
+	child.walk(target.publish)
+}
+
+// Use adds middleware to the routes added to g from now on.
+func (g *Group) Use(middleware ...Middleware) {
+	g.middleware = append(g.middleware, middleware...)
+}
+
+// Get adds a handler for the 'GET' http method in group g.
+func (g *Group) Get(route string, handler interface{}, middleware ...Middleware) *Route {
+	return g.add("GET", route, handler, middleware)
+}
+
+// Post adds a handler for the 'POST' http method in group g.
+func (g *Group) Post(route string, handler interface{}, middleware ...Middleware) *Route {
+	return g.add("POST", route, handler, middleware)
+}
+
+// Put adds a handler for the 'PUT' http method in group g.
+func (g *Group) Put(route string, handler interface{}, middleware ...Middleware) *Route {
+	return g.add("PUT", route, handler, middleware)
+}
+
+// Delete adds a handler for the 'DELETE' http method in group g.
+func (g *Group) Delete(route string, handler interface{}, middleware ...Middleware) *Route {
+	return g.add("DELETE", route, handler, middleware)
+}
+
+// Match adds a handler for an arbitrary http method in group g.
+func (g *Group) Match(method string, route string, handler interface{}, middleware ...Middleware) *Route {
+	return g.add(method, route, handler, middleware)
+}
+
+// Handler adds a custom http.Handler in group g.
+func (g *Group) Handler(route string, method string, handler http.Handler, middleware ...Middleware) *Route {
+	return g.add(method, route, handler, middleware)
+}
+
+// add adds a route to g. The settings of the returned route apply to all
+// the copies of it registered with servers.
+func (g *Group) add(method, route string, handler interface{}, middleware []Middleware) *Route {
+	r := newRoute(route, method, handler, middleware)
+	g.routes = append(g.routes, r)
+	g.publish(r)
+	return r
+}
+
+// wrap returns a copy of r as seen from outside of g: under the prefix of
+// g, after the middleware of g.
+func (g *Group) wrap(r *Route) *Route {
+	cp := *r
+	middleware := make([]Middleware, 0, len(g.middleware)+len(r.middleware))
+	middleware = append(middleware, g.middleware...)
+	cp.middleware = append(middleware, r.middleware...)
+	cp.r = g.prefix + r.r
+	return &cp
+}
+
+// publish registers r, a route of g or of a group mounted on g, with the
+// server of g and the groups g is mounted on.
+func (g *Group) publish(r *Route) {
+	r = g.wrap(r)
+	if g.server != nil {
+		g.server.routes.add(r)
+	}
+	for _, m := range g.mounts {
+		m.publish(r)
+	}
+}
+
+// walk calls fn for every route of g and of the groups mounted on it.
+func (g *Group) walk(fn func(*Route)) {
+	for _, r := range g.routes {
+		fn(g.wrap(r))
+	}
+	for _, child := range g.groups {
+		child.walk(func(r *Route) {
+			fn(g.wrap(r))
+		})
+	}
+}
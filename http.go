@@ -21,7 +21,9 @@ package next
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -32,26 +34,55 @@ import (
 	"os"
 	"reflect"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Middleware wraps the handling of a request. It may inspect or change ctx
+// before calling ctx.Next, run code after ctx.Next returns, or stop the
+// chain with ctx.Abort. A middleware that returns without calling ctx.Next
+// lets the chain continue with the next one.
+type Middleware func(ctx *Context)
+
 type Server struct {
-	Config *Config
-	routes *Routes
-	Logger *log.Logger
-	Env    map[string]interface{}
+	Config     *Config
+	routes     *Routes
+	middleware []Middleware
+	codecs     []codec
+	views      views
+	sessions   sessions
+	proxies    proxies
+	Logger     *log.Logger
+	Log        *Logger
+	access     accessLog
+	Env        map[string]interface{}
+	// ErrorHandler writes the response for errors returned by handlers
+	ErrorHandler ErrorHandler
+	// AccessLog makes the access log lines, over the log.format setting
+	AccessLog AccessFormatter
 	//save the listener so it can be closed
 	l net.Listener
+
+	// http server and the state of its connections, for Shutdown
+	mu      sync.Mutex
+	srv     *http.Server
+	conns   map[net.Conn]http.ConnState
+	sockets webSockets
 }
 
 func NewServer() *Server {
 	server := &Server{
 		Config: NewConfig(),
 		routes: NewRoutes(),
+		codecs: defaultCodecs(),
 		Logger: log.New(os.Stdout, "", log.Ldate|log.Ltime),
+		Log:    Std,
 		Env:    map[string]interface{}{},
+
+		ErrorHandler: DefaultErrorHandler,
 	}
 
 	// Load default config if exists
@@ -70,40 +101,43 @@ func (s *Server) ServeHTTP(c http.ResponseWriter, req *http.Request) {
 
 // Process invokes the routing system for server s
 func (s *Server) Process(c http.ResponseWriter, req *http.Request) {
-	route := s.routeHandler(req, c)
-	if route != nil {
-		route.httpHandler.ServeHTTP(c, req)
-	}
+	s.routeHandler(req, c)
+}
+
+// Use adds middleware that runs for every request of server s, before the
+// middleware given to a single route.
+func (s *Server) Use(middleware ...Middleware) {
+	s.middleware = append(s.middleware, middleware...)
 }
 
 // Get adds a handler for the 'GET' http method for server s.
-func (s *Server) Get(route string, handler interface{}) {
-	s.routes.Add(route, "GET", handler)
+func (s *Server) Get(route string, handler interface{}, middleware ...Middleware) *Route {
+	return s.routes.Add(route, "GET", handler, middleware...)
 }
 
 // Post adds a handler for the 'POST' http method for server s.
-func (s *Server) Post(route string, handler interface{}) {
-	s.routes.Add(route, "POST", handler)
+func (s *Server) Post(route string, handler interface{}, middleware ...Middleware) *Route {
+	return s.routes.Add(route, "POST", handler, middleware...)
 }
 
 // Put adds a handler for the 'PUT' http method for server s.
-func (s *Server) Put(route string, handler interface{}) {
-	s.routes.Add(route, "PUT", handler)
+func (s *Server) Put(route string, handler interface{}, middleware ...Middleware) *Route {
+	return s.routes.Add(route, "PUT", handler, middleware...)
 }
 
 // Delete adds a handler for the 'DELETE' http method for server s.
-func (s *Server) Delete(route string, handler interface{}) {
-	s.routes.Add(route, "DELETE", handler)
+func (s *Server) Delete(route string, handler interface{}, middleware ...Middleware) *Route {
+	return s.routes.Add(route, "DELETE", handler, middleware...)
 }
 
 // Match adds a handler for an arbitrary http method for server s.
-func (s *Server) Match(method string, route string, handler interface{}) {
-	s.routes.Add(route, method, handler)
+func (s *Server) Match(method string, route string, handler interface{}, middleware ...Middleware) *Route {
+	return s.routes.Add(route, method, handler, middleware...)
 }
 
 //Adds a custom handler. Only for webserver mode. Will have no effect when running as FCGI or SCGI.
-func (s *Server) Handler(route string, method string, handler http.Handler) {
-	s.routes.Add(route, method, handler)
+func (s *Server) Handler(route string, method string, handler http.Handler, middleware ...Middleware) *Route {
+	return s.routes.Add(route, method, handler, middleware...)
 }
 
 // Run starts the web application and serves HTTP requests for s
@@ -124,7 +158,7 @@ func (s *Server) Run(addr string) {
 		log.Fatal("ListenAndServe:", err)
 	}
 	s.l = l
-	err = http.Serve(s.l, mux)
+	err = s.serve(mux)
 	s.l.Close()
 }
 
@@ -139,7 +173,32 @@ func (s *Server) RunTLS(addr string, config *tls.Config) error {
 	}
 
 	s.l = l
-	return http.Serve(s.l, mux)
+	return s.serve(mux)
+}
+
+// serve accepts connections on s.l, keeping track of their state.
+func (s *Server) serve(handler http.Handler) error {
+	s.mu.Lock()
+	s.srv = &http.Server{Handler: handler, ConnState: s.trackConn}
+	srv := s.srv
+	s.mu.Unlock()
+
+	return srv.Serve(s.l)
+}
+
+func (s *Server) trackConn(c net.Conn, state http.ConnState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]http.ConnState)
+	}
+	switch state {
+	case http.StateHijacked, http.StateClosed:
+		delete(s.conns, c)
+	default:
+		s.conns[c] = state
+	}
 }
 
 // Close stops server s.
@@ -149,6 +208,41 @@ func (s *Server) Close() {
 	}
 }
 
+// Shutdown stops server s gracefully. It stops accepting connections,
+// closes the idle ones and waits for the requests in flight until ctx is
+// done. Connections still busy then are closed, and reported in a
+// *ShutdownError. WebSockets are closed with CloseGoingAway right away.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeWebSockets()
+
+	s.mu.Lock()
+	srv := s.srv
+	s.mu.Unlock()
+
+	if srv == nil {
+		s.Close()
+		return nil
+	}
+
+	err := srv.Shutdown(ctx)
+	if err == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	forced := make([]string, 0, len(s.conns))
+	for c, state := range s.conns {
+		if state == http.StateActive || state == http.StateNew {
+			forced = append(forced, c.RemoteAddr().String())
+		}
+	}
+	s.mu.Unlock()
+
+	srv.Close()
+	sort.Strings(forced)
+	return &ShutdownError{Forced: forced, Err: err}
+}
+
 // safelyCall invokes `function` in recover block
 func (s *Server) safelyCall(function reflect.Value, args []reflect.Value) (resp []reflect.Value, e interface{}) {
 	defer func() {
@@ -194,49 +288,145 @@ func requiresContext(handlerType reflect.Type) bool {
 	return false
 }
 
-func (s *Server) logRequest(ctx Context, sTime time.Time) {
-	//log the request
-	var logEntry bytes.Buffer
-	req := ctx.Request
-	requestPath := req.URL.Path
-
-	duration := time.Now().Sub(sTime)
-	var client string
+func (s *Server) logRequest(ctx *Context, sTime time.Time) {
+	status := ctx.Status()
+	if status == 0 {
+		status = 200
+	}
+	var conf *routeConf
+	if ctx.route != nil {
+		conf = ctx.route.conf
+	}
+	if !sampled(conf, status) {
+		return
+	}
 
-	// We suppose RemoteAddr is of the form Ip:Port as specified in the Request
-	// documentation at http://golang.org/pkg/net/http/#Request
-	pos := strings.LastIndex(req.RemoteAddr, ":")
-	if pos > 0 {
-		client = req.RemoteAddr[0:pos]
-	} else {
+	req := ctx.Request
+	client, err := ctx.ClientIp()
+	if err != nil {
 		client = req.RemoteAddr
 	}
-
-	fmt.Fprintf(&logEntry, "%s - \033[32;1m %s %s\033[0m - %v", client, req.Method, requestPath, duration)
-
-	if len(ctx.Params) > 0 {
-		fmt.Fprintf(&logEntry, " - \033[37;1mParams: %v\033[0m\n", ctx.Params)
+	format, redact := s.access.load(s.Config, s.Logger)
+	if s.AccessLog != nil {
+		format = s.AccessLog
 	}
-
-	ctx.Server.Logger.Print(logEntry.String())
+	s.access.write(s.Logger, format, &AccessEntry{
+		Time:      sTime,
+		RequestID: ctx.reqID,
+		Client:    client,
+		Method:    req.Method,
+		Path:      req.URL.Path,
+		URI:       redactURI(req.URL, redact),
+		Proto:     req.Proto,
+		Status:    status,
+		Bytes:     ctx.Size(),
+		Latency:   time.Since(sTime),
+		Referer:   req.Referer(),
+		UserAgent: req.UserAgent(),
+		Params:    redactParams(ctx.Params, redact),
+	})
 }
 
 // the main route handler in next
 // Tries to handle the given request.
-// Finds the route matching the request, and execute the callback associated
-// with it.  In case of custom http handlers, this function returns an "unused"
-// route. The caller is then responsible for calling the httpHandler associated
-// with the returned route.
-func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) (unused *Route) {
+// Finds the route matching the request, and runs the middleware chain of
+// the server and of the route, ending with the callback associated with it.
+func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) {
 	requestPath := req.URL.Path
-	ctx := Context{req, map[string]string{}, s, w}
+	rw := &responseWriter{ResponseWriter: w}
+	ctx := &Context{
+		Request:        req,
+		Params:         map[string]string{},
+		Server:         s,
+		ResponseWriter: rw,
+		writer:         rw,
+		reqID:          req.Header.Get(RequestIDHeader),
+	}
+	if !validRequestID(ctx.reqID) {
+		ctx.reqID = newRequestID()
+	}
 
 	//set some default headers
 	ctx.SetHeader("Server", "next", true)
+	ctx.SetHeader(RequestIDHeader, ctx.reqID, true)
 	tm := time.Now().UTC()
 
+	route, params := s.routes.Match(requestPath, req.Method)
+	ctx.route, ctx.pathParams = route, params
+
+	conf := routeConf{}
+	if route != nil {
+		conf = *route.conf
+	}
+	fits := s.parseParams(ctx, conf)
+
+	ctx.SetHeader("Date", webTime(tm), true)
+
+	chain := make([]Middleware, 0, len(s.middleware)+1)
+	chain = append(chain, s.middleware...)
+
+	switch {
+	case !fits:
+		chain = append(chain, func(ctx *Context) {
+			ctx.Abort(413, "Request Entity Too Large")
+		})
+	case route == nil:
+		chain = append(chain, s.noRoute(requestPath))
+	default:
+		//Set the default content-type
+		ctx.SetHeader("Content-Type", "text/html; charset=utf-8", true)
+
+		if route.conf.cors != nil {
+			// without the CORS middleware, or before the one of a group
+			chain = append(chain, func(ctx *Context) { ctx.cors(nil) })
+		}
+		chain = append(chain, route.middleware...)
+		chain = append(chain, s.callHandler(route))
+	}
+
+	ctx.chain = chain
+	s.runChain(ctx, s.handlerTimeout(conf), tm, func() {
+		ctx.removeUploads()
+		ctx.closeEvents()
+	})
+}
+
+// DefaultMaxBody is the size limit of request bodies, in bytes, when the
+// http.max_body setting is not set. A negative setting lifts the limit.
+const DefaultMaxBody = 32 << 20
+
+// parseParams fills ctx.Params from the query string and, unless the route
+// streams its body, from the body of the request, which is read in full.
+// It is false when the body is over the size limit of the route.
+func (s *Server) parseParams(ctx *Context, conf routeConf) bool {
+	req := ctx.Request
+
+	limit := conf.maxBody
+	if limit == 0 {
+		limit = int64(s.Config.Int("http.max_body"))
+	}
+	if limit == 0 {
+		limit = DefaultMaxBody
+	}
+	if limit > 0 {
+		if req.ContentLength > limit {
+			return false
+		}
+		req.Body = http.MaxBytesReader(ctx.ResponseWriter, req.Body, limit)
+	}
+
+	if conf.stream {
+		ctx.stream = true
+		for k, v := range req.URL.Query() {
+			ctx.Params[k] = v[0]
+		}
+		return true
+	}
+
 	//ignore errors from ParseForm because it's usually harmless.
-	req.ParseForm()
+	if err := req.ParseForm(); isBodyTooLarge(err) {
+		return false
+	}
 	if len(req.Form) > 0 {
 		for k, v := range req.Form {
 			ctx.Params[k] = v[0]
@@ -250,8 +440,11 @@ func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) (unused
 
 	// Data in body
 	// ------------------
-	requestbody, _ := ioutil.ReadAll(req.Body)
+	requestbody, err := ioutil.ReadAll(req.Body)
 	req.Body.Close()
+	if isBodyTooLarge(err) {
+		return false
+	}
 	bf := bytes.NewBuffer(requestbody)
 	req.Body = ioutil.NopCloser(bf)
 
@@ -269,64 +462,185 @@ func (s *Server) routeHandler(req *http.Request, w http.ResponseWriter) (unused
 			ctx.Params[k] = v
 		}
 	}
+	// give the whole body back to the handler
+	ctx.body = requestbody
+	req.Body = ioutil.NopCloser(bytes.NewReader(requestbody))
 	// ------------------
+	return true
+}
 
-	defer s.logRequest(ctx, tm)
+func isBodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
 
-	ctx.SetHeader("Date", webTime(tm), true)
+// noRoute returns the last link of a middleware chain for a request that
+// matched no route. When other methods are registered for the path, it
+// answers OPTIONS with the allowed methods and any other method with 405
+// Method Not Allowed; otherwise it answers 404. A preflight request gets
+// the CORS policy of the route it asks for, see Route.CORS.
+func (s *Server) noRoute(path string) Middleware {
+	return func(ctx *Context) {
+		allowed := s.routes.Allowed(path)
+		if len(allowed) == 0 {
+			ctx.Abort(404, "Page not found")
+			return
+		}
 
-	route := s.routes.Match(requestPath, req.Method)
-	if route == nil {
-		ctx.Abort(404, "Page not found")
-		return
+		ctx.SetHeader("Allow", strings.Join(allowed, ", "), true)
+		if ctx.Request.Method == "OPTIONS" {
+			// a preflight request for a route with a CORS policy
+			ctx.cors(nil)
+			if ctx.aborted {
+				return
+			}
+			ctx.ResponseWriter.WriteHeader(204)
+			return
+		}
+		ctx.Abort(405, "Method not allowed")
 	}
-	cr := route.cr
+}
 
-	//Set the default content-type
-	ctx.SetHeader("Content-Type", "text/html; charset=utf-8", true)
+// callHandler returns the last link of a middleware chain, which invokes
+// the handler of route and writes its return value.
+func (s *Server) callHandler(route *Route) Middleware {
+	return func(ctx *Context) {
+		if route.httpHandler != nil {
+			route.httpHandler.ServeHTTP(ctx.ResponseWriter, ctx.Request)
+			return
+		}
 
-	if route.httpHandler != nil {
-		unused = route
-		// We can not handle custom http handlers here, give back to the caller.
-		return
-	}
+		var args []reflect.Value
+		handlerType := route.handler.Type()
+		if requiresContext(handlerType) {
+			args = append(args, reflect.ValueOf(ctx))
+		}
+
+		for _, param := range ctx.pathParams {
+			args = append(args, reflect.ValueOf(param.Value))
+		}
 
-	var args []reflect.Value
-	handlerType := route.handler.Type()
-	if requiresContext(handlerType) {
-		args = append(args, reflect.ValueOf(&ctx))
+		ret, err := s.safelyCall(route.handler, args)
+		if err != nil {
+			//there was an error or panic while calling the handler
+			ctx.serverError()
+		}
+		if len(ret) == 0 || ctx.aborted {
+			return
+		}
+
+		s.writeResult(ctx, ret)
 	}
+}
 
-	match := cr.FindStringSubmatch(requestPath)
-	for _, arg := range match[1:] {
-		args = append(args, reflect.ValueOf(arg))
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// writeResult writes the values returned by a handler. A handler may
+// return nothing, a body, a status and a body, or either of the last two
+// followed by an error. A non-nil error goes to the ErrorHandler of s
+// instead of the body. A string or []byte body is written as is; any other
+// value is encoded to JSON. Handlers returning anything else are rejected
+// when their route is added, see checkResults.
+func (s *Server) writeResult(ctx *Context, ret []reflect.Value) {
+	if last := ret[len(ret)-1]; last.Type().Implements(errorType) {
+		if !isNilValue(last) {
+			s.handleError(ctx, last.Interface().(error))
+			return
+		}
+		ret = ret[:len(ret)-1]
 	}
 
-	ret, err := s.safelyCall(route.handler, args)
-	if err != nil {
-		//there was an error or panic while calling the handler
-		ctx.Abort(500, "Server Error")
+	status := 0
+	if len(ret) == 2 {
+		switch ret[0].Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			status = int(ret[0].Int())
+		}
+		ret = ret[1:]
 	}
 	if len(ret) == 0 {
+		if status != 0 {
+			ctx.ResponseWriter.WriteHeader(status)
+		}
 		return
 	}
 
 	sval := ret[0]
+	if sval.Kind() == reflect.Interface && !sval.IsNil() {
+		sval = sval.Elem()
+	}
+	if sval.IsValid() && sval.Type().Implements(errorType) {
+		s.handleError(ctx, sval.Interface().(error))
+		return
+	}
 
 	var content []byte
 
 	if sval.Kind() == reflect.String {
 		content = []byte(sval.String())
 	} else if sval.Kind() == reflect.Slice && sval.Type().Elem().Kind() == reflect.Uint8 {
-		content = sval.Interface().([]byte)
+		content = sval.Bytes()
+	} else {
+		json := &Json{}
+		if sval.IsValid() {
+			json.data = sval.Interface()
+		}
+		out, err := json.Encode()
+		if err != nil {
+			s.handleError(ctx, err)
+			return
+		}
+		ctx.ContentType("json")
+		content = out
 	}
 	ctx.SetHeader("Content-Length", strconv.Itoa(len(content)), true)
-	_, err = ctx.ResponseWriter.Write(content)
+	if status != 0 {
+		ctx.ResponseWriter.WriteHeader(status)
+	}
+	_, err := ctx.ResponseWriter.Write(content)
 	if err != nil {
 		ctx.Server.Logger.Println("Error during write: ", err)
 	}
+}
 
-	return
+// isNilValue reports whether v is a nil interface, pointer, map, slice,
+// func or chan.
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	}
+	return false
+}
+
+// checkResults reports an error when the results of a handler of type t
+// are not some writeResult knows: a body, a status and a body, or either
+// followed by an error.
+func checkResults(t reflect.Type) error {
+	n := t.NumOut()
+	if n > 0 && t.Out(n-1).Implements(errorType) {
+		n--
+	}
+	switch {
+	case n > 2:
+		return fmt.Errorf("next: handler %v returns too many values", t)
+	case n == 2:
+		switch t.Out(0).Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		default:
+			return fmt.Errorf("next: handler %v returns a %v where a status is expected", t, t.Out(0))
+		}
+	}
+	return nil
+}
+
+// handleError hands err over to the ErrorHandler of s.
+func (s *Server) handleError(ctx *Context, err error) {
+	if s.ErrorHandler == nil {
+		DefaultErrorHandler(ctx, err)
+		return
+	}
+	s.ErrorHandler(ctx, err)
 }
 
 // SetLogger sets the logger for server s
@@ -0,0 +1,283 @@
+package next
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer() *Server {
+	s := NewServer()
+	s.Logger.SetOutput(new(strings.Builder))
+	return s
+}
+
+func serve(s *Server, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	s := newTestServer()
+
+	var trace []string
+	s.Use(func(ctx *Context) {
+		trace = append(trace, "global before")
+		ctx.Next()
+		trace = append(trace, "global after")
+	})
+	s.Get("/", func() string {
+		trace = append(trace, "handler")
+		return "ok"
+	}, func(ctx *Context) {
+		trace = append(trace, "route")
+	})
+
+	w := serve(s, "GET", "/")
+	if w.Body.String() != "ok" {
+		t.Errorf("body = %q, want ok", w.Body.String())
+	}
+
+	want := "global before,route,handler,global after"
+	if got := strings.Join(trace, ","); got != want {
+		t.Errorf("trace = %s, want %s", got, want)
+	}
+}
+
+func TestMiddlewareAbort(t *testing.T) {
+	s := newTestServer()
+
+	called := false
+	s.Use(func(ctx *Context) {
+		if ctx.Request.Header.Get("Authorization") == "" {
+			ctx.Abort(401, "unauthorized")
+		}
+	})
+	s.Get("/", func() string {
+		called = true
+		return "ok"
+	})
+
+	w := serve(s, "GET", "/")
+	if w.Code != 401 || w.Body.String() != "unauthorized" {
+		t.Errorf("got %d %q, want 401 unauthorized", w.Code, w.Body.String())
+	}
+	if called {
+		t.Error("handler was called after Abort")
+	}
+}
+
+func TestMiddlewareHttpHandler(t *testing.T) {
+	s := newTestServer()
+
+	s.Use(func(ctx *Context) {
+		ctx.SetHeader("X-Test", "1", true)
+	})
+	s.Handler("/raw", "GET", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("raw"))
+	}))
+
+	w := serve(s, "GET", "/raw")
+	if w.Body.String() != "raw" || w.Header().Get("X-Test") != "1" {
+		t.Errorf("got %q with X-Test %q", w.Body.String(), w.Header().Get("X-Test"))
+	}
+}
+
+func TestGroup(t *testing.T) {
+	s := newTestServer()
+
+	var trace []string
+	mw := func(name string) Middleware {
+		return func(ctx *Context) {
+			trace = append(trace, name)
+		}
+	}
+
+	admin := s.Group("/api/v1/admin", mw("admin"))
+	admin.Get("/users", func() string { return "users" })
+	admin.Group("/stats", mw("stats")).Get("/daily", func() string { return "daily" })
+
+	if w := serve(s, "GET", "/api/v1/admin/stats/daily"); w.Body.String() != "daily" {
+		t.Errorf("body = %q, want daily", w.Body.String())
+	}
+	if got := strings.Join(trace, ","); got != "admin,stats" {
+		t.Errorf("trace = %s, want admin,stats", got)
+	}
+	if w := serve(s, "GET", "/api/v1/admin/users"); w.Body.String() != "users" {
+		t.Errorf("body = %q, want users", w.Body.String())
+	}
+}
+
+func TestGroupMount(t *testing.T) {
+	g := NewGroup("/v1")
+	g.Get("/ping", func() string { return "pong" })
+
+	a, b := newTestServer(), newTestServer()
+	a.Mount("/api", g)
+	b.Mount("", g)
+	// routes added after mounting reach every server
+	g.Get("/late", func() string { return "late" })
+
+	for _, test := range []struct {
+		s    *Server
+		path string
+		body string
+	}{
+		{a, "/api/v1/ping", "pong"},
+		{a, "/api/v1/late", "late"},
+		{b, "/v1/ping", "pong"},
+		{b, "/v1/late", "late"},
+	} {
+		if w := serve(test.s, "GET", test.path); w.Body.String() != test.body {
+			t.Errorf("%s: body = %q, want %q", test.path, w.Body.String(), test.body)
+		}
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	s := newTestServer()
+	s.Get("/users/:id", func() string { return "get" })
+	s.Delete("/users/:id", func() string { return "delete" })
+	s.Post(`/users/(\d+)`, func() string { return "post" })
+
+	w := serve(s, "PUT", "/users/1")
+	if w.Code != 405 || w.Header().Get("Allow") != "DELETE, GET, HEAD, OPTIONS, POST" {
+		t.Errorf("PUT: got %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+
+	w = serve(s, "OPTIONS", "/users/1")
+	if w.Code != 204 || w.Header().Get("Allow") != "DELETE, GET, HEAD, OPTIONS, POST" {
+		t.Errorf("OPTIONS: got %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+
+	// the regular expression route does not match, only the tree ones do
+	w = serve(s, "OPTIONS", "/users/fred")
+	if w.Header().Get("Allow") != "DELETE, GET, HEAD, OPTIONS" {
+		t.Errorf("OPTIONS: got Allow %q", w.Header().Get("Allow"))
+	}
+
+	if w = serve(s, "GET", "/groups"); w.Code != 404 {
+		t.Errorf("GET /groups: got %d, want 404", w.Code)
+	}
+}
+
+func TestHandlerResults(t *testing.T) {
+	s := newTestServer()
+
+	type user struct {
+		Name string `json:"name"`
+	}
+	s.Get("/status", func() (int, string) { return 201, "created" })
+	s.Get("/struct", func() user { return user{"fred"} })
+	s.Get("/map", func() (map[string]int, error) { return map[string]int{"n": 1}, nil })
+	s.Get("/error", func() error { return errors.New("boom") })
+	s.Get("/http-error", func() (*user, error) {
+		return nil, NewHTTPError(404, "user_not_found", "no such user")
+	})
+	s.Get("/nil-error", func() error { return nil })
+	s.Get("/typed-error", func() (string, *HTTPError) { return "", NewHTTPError(403, "", "no") })
+	s.Get("/typed-nil", func() (string, *HTTPError) { return "fine", nil })
+
+	tests := []struct {
+		path  string
+		code  int
+		ctype string
+		body  string
+	}{
+		{"/status", 201, "text/html; charset=utf-8", "created"},
+		{"/struct", 200, "application/json", `{"name":"fred"}`},
+		{"/map", 200, "application/json", `{"n":1}`},
+		{"/error", 500, "application/json", `{"code":"500","msg":"Server Error"}`},
+		{"/http-error", 404, "application/json", `{"code":"user_not_found","msg":"no such user"}`},
+		{"/nil-error", 200, "text/html; charset=utf-8", ""},
+		{"/typed-error", 403, "application/json", `{"code":"403","msg":"no"}`},
+		{"/typed-nil", 200, "text/html; charset=utf-8", "fine"},
+	}
+	for _, test := range tests {
+		w := serve(s, "GET", test.path)
+		if w.Code != test.code || w.Header().Get("Content-Type") != test.ctype || w.Body.String() != test.body {
+			t.Errorf("%s: got %d %q %q, want %d %q %q", test.path,
+				w.Code, w.Header().Get("Content-Type"), w.Body.String(),
+				test.code, test.ctype, test.body)
+		}
+	}
+}
+
+func TestHandlerResultsRejected(t *testing.T) {
+	for _, handler := range []interface{}{
+		func() (string, string) { return "a", "b" },
+		func() (int, string, int, error) { return 200, "a", 1, nil },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%T registered", handler)
+				}
+			}()
+			newTestServer().Get("/", handler)
+		}()
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	s := newTestServer()
+	s.ErrorHandler = func(ctx *Context, err error) {
+		ctx.Abort(418, err.Error())
+	}
+	s.Get("/", func() (string, error) { return "", errors.New("teapot") })
+
+	if w := serve(s, "GET", "/"); w.Code != 418 || w.Body.String() != "teapot" {
+		t.Errorf("got %d %q, want 418 teapot", w.Code, w.Body.String())
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	s := newTestServer()
+	s.Config.Read([]byte(`{"http": {"max_body": 8}}`))
+	s.Post("/small", func() string { return "ok" })
+	s.Post("/big", func() string { return "ok" }).MaxBody(1 << 10)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return w
+	}
+	if w := post("/small", "0123456789"); w.Code != 413 {
+		t.Errorf("global limit: got %d, want 413", w.Code)
+	}
+	if w := post("/big", "0123456789"); w.Code != 200 {
+		t.Errorf("route limit: got %d, want 200", w.Code)
+	}
+
+	// a body of unknown length is cut at the limit
+	req := httptest.NewRequest("POST", "/small", strings.NewReader("0123456789"))
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != 413 {
+		t.Errorf("chunked body: got %d, want 413", w.Code)
+	}
+}
+
+func TestStreamRoute(t *testing.T) {
+	s := newTestServer()
+	var n int64
+	s.Post("/upload", func(ctx *Context) string {
+		if ctx.Params["name"] != "a" {
+			t.Errorf("query param = %q", ctx.Params["name"])
+		}
+		n, _ = io.Copy(io.Discard, ctx.Request.Body)
+		return "ok"
+	}).Stream()
+
+	body := strings.Repeat("x", 1<<10)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/upload?name=a", strings.NewReader(body)))
+	if w.Body.String() != "ok" || n != 1<<10 {
+		t.Errorf("got %q, read %d bytes", w.Body.String(), n)
+	}
+}
@@ -311,6 +311,44 @@ func (l *Logger) Panicln(v ...interface{}) {
 
 // -----------------------------------------
 
+// A RequestLogger writes to a Logger the lines about a request, stamped
+// with its ID.
+type RequestLogger struct {
+	l     *Logger
+	reqId string
+}
+
+// Request returns a logger stamping reqId on the lines it writes to l.
+func (l *Logger) Request(reqId string) *RequestLogger {
+	return &RequestLogger{l: l, reqId: reqId}
+}
+
+func (r *RequestLogger) Debugf(format string, v ...interface{}) {
+	r.l.Output(r.reqId, Ldebug, 2, fmt.Sprintf(format, v...))
+}
+
+func (r *RequestLogger) Debug(v ...interface{}) { r.l.Output(r.reqId, Ldebug, 2, fmt.Sprintln(v...)) }
+
+func (r *RequestLogger) Infof(format string, v ...interface{}) {
+	r.l.Output(r.reqId, Linfo, 2, fmt.Sprintf(format, v...))
+}
+
+func (r *RequestLogger) Info(v ...interface{}) { r.l.Output(r.reqId, Linfo, 2, fmt.Sprintln(v...)) }
+
+func (r *RequestLogger) Warnf(format string, v ...interface{}) {
+	r.l.Output(r.reqId, Lwarn, 2, fmt.Sprintf(format, v...))
+}
+
+func (r *RequestLogger) Warn(v ...interface{}) { r.l.Output(r.reqId, Lwarn, 2, fmt.Sprintln(v...)) }
+
+func (r *RequestLogger) Errorf(format string, v ...interface{}) {
+	r.l.Output(r.reqId, Lerror, 2, fmt.Sprintf(format, v...))
+}
+
+func (r *RequestLogger) Error(v ...interface{}) { r.l.Output(r.reqId, Lerror, 2, fmt.Sprintln(v...)) }
+
+// -----------------------------------------
+
 func (l *Logger) Stack(v ...interface{}) {
 	s := fmt.Sprint(v...)
 	s += "\n"
@@ -358,6 +396,13 @@ func (l *Logger) SetPrefix(prefix string) {
 	l.prefix = prefix
 }
 
+// SetOutput sets the output destination for the logger.
+func (l *Logger) SetOutput(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out = w
+}
+
 // SetOutputLevel sets the output level for the logger.
 func (l *Logger) SetOutputLevel(lvl int) {
 	l.mu.Lock()
@@ -1,6 +1,9 @@
 package next
 
 import (
+    "context"
+    "time"
+
     "gopkg.in/mgo.v2"
     "gopkg.in/mgo.v2/bson"
 )
@@ -8,6 +11,8 @@ import (
 type MongoDB struct {
     session *mgo.Session
     db string
+    // ctx bounds the calls, see WithContext
+    ctx context.Context
 }
 
 func NewMongoDB() *MongoDB {
@@ -28,13 +33,41 @@ func (mongo *MongoDB) Close() {
     mongo.session.Close()
 }
 
+// WithContext returns a copy of mongo whose calls fail once ctx is done,
+// and time out at its deadline, as in mongo.WithContext(ctx.Context())
+// for the calls of a request. The sessions of the copy, see Session, are
+// bound the same way.
+func (mongo *MongoDB) WithContext(ctx context.Context) *MongoDB {
+    m := *mongo
+    m.ctx = ctx
+    return &m
+}
+
 func (mongo *MongoDB) Session() *mgo.Session {
     s := mongo.session.Copy()
     s.SetMode(mgo.Strong, true)
+    if mongo.ctx == nil {
+        return s
+    }
+    // mgo takes no context: a session of a context done already times
+    // out at once. A zero timeout would be none.
+    timeout := time.Duration(0)
+    if mongo.ctx.Err() != nil {
+        timeout = time.Nanosecond
+    } else if deadline, ok := mongo.ctx.Deadline(); ok {
+        timeout = timeLeft(deadline)
+    }
+    if timeout > 0 {
+        s.SetSyncTimeout(timeout)
+        s.SetSocketTimeout(timeout)
+    }
     return s
 }
 
 func (mongo *MongoDB) InsertOrUpdate(collection string, buziKey string, buziValue interface{}, d interface{}, result interface{}) (error) {
+    if mongo.ctx != nil && mongo.ctx.Err() != nil {
+        return mongo.ctx.Err()
+    }
     s := mongo.Session()
     defer s.Close()
 
@@ -0,0 +1,223 @@
+package next
+
+import (
+	"bufio"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// encodeMsgpack writes v in the MessagePack format. Structs are written
+// as maps, named and filtered by their `json` tags like encoding/json
+// does; values implementing encoding.TextMarshaler are written as strings.
+func encodeMsgpack(w io.Writer, v interface{}) error {
+	bw := bufio.NewWriter(w)
+	if err := (&msgpackEncoder{bw}).encode(reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+type msgpackEncoder struct {
+	w *bufio.Writer
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+func (e *msgpackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		return e.w.WriteByte(0xc0)
+	}
+	if j, ok := v.Interface().(*Json); ok && j != nil {
+		return e.encode(reflect.ValueOf(j.data))
+	}
+	if v.Type().Implements(textMarshalerType) && !(v.Kind() == reflect.Ptr && v.IsNil()) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		e.writeString(string(text))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return e.w.WriteByte(0xc0)
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return e.w.WriteByte(0xc3)
+		}
+		return e.w.WriteByte(0xc2)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.writeUint(v.Uint())
+	case reflect.Float32:
+		e.w.WriteByte(0xca)
+		e.writeBig(uint64(math.Float32bits(float32(v.Float()))), 4)
+	case reflect.Float64:
+		e.w.WriteByte(0xcb)
+		e.writeBig(math.Float64bits(v.Float()), 8)
+	case reflect.String:
+		e.writeString(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return e.w.WriteByte(0xc0)
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.writeHeader(len(b), 0, 0, 0xc4, 0xc5, 0xc6)
+			e.w.Write(b)
+			return nil
+		}
+		e.writeHeader(v.Len(), 0x90, 16, 0, 0xdc, 0xdd)
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return e.w.WriteByte(0xc0)
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		e.writeHeader(len(keys), 0x80, 16, 0, 0xde, 0xdf)
+		for _, k := range keys {
+			if err := e.encode(k); err != nil {
+				return err
+			}
+			if err := e.encode(v.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("next: can not encode %s to msgpack", v.Type())
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeStruct(v reflect.Value) error {
+	type field struct {
+		name string
+		v    reflect.Value
+	}
+	var fields []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		fv := v.Field(i)
+		omitEmpty := false
+		for _, opt := range tag[1:] {
+			omitEmpty = omitEmpty || opt == "omitempty"
+		}
+		if omitEmpty && fv.IsZero() {
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, field{name, fv})
+	}
+
+	e.writeHeader(len(fields), 0x80, 16, 0, 0xde, 0xdf)
+	for _, f := range fields {
+		e.writeString(f.name)
+		if err := e.encode(f.v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) writeInt(n int64) {
+	switch {
+	case n >= 0:
+		e.writeUint(uint64(n))
+	case n >= -32:
+		e.w.WriteByte(byte(n))
+	case n >= math.MinInt8:
+		e.w.WriteByte(0xd0)
+		e.w.WriteByte(byte(n))
+	case n >= math.MinInt16:
+		e.w.WriteByte(0xd1)
+		e.writeBig(uint64(n), 2)
+	case n >= math.MinInt32:
+		e.w.WriteByte(0xd2)
+		e.writeBig(uint64(n), 4)
+	default:
+		e.w.WriteByte(0xd3)
+		e.writeBig(uint64(n), 8)
+	}
+}
+
+func (e *msgpackEncoder) writeUint(n uint64) {
+	switch {
+	case n <= math.MaxInt8:
+		e.w.WriteByte(byte(n))
+	case n <= math.MaxUint8:
+		e.w.WriteByte(0xcc)
+		e.w.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		e.w.WriteByte(0xcd)
+		e.writeBig(n, 2)
+	case n <= math.MaxUint32:
+		e.w.WriteByte(0xce)
+		e.writeBig(n, 4)
+	default:
+		e.w.WriteByte(0xcf)
+		e.writeBig(n, 8)
+	}
+}
+
+func (e *msgpackEncoder) writeString(s string) {
+	e.writeHeader(len(s), 0xa0, 32, 0xd9, 0xda, 0xdb)
+	e.w.WriteString(s)
+}
+
+// writeHeader writes the type and length of a string, binary, array or map
+// of n items: in the fix byte when n is below fixMax, else in the 8, 16 or
+// 32 bit form. A zero marker means the format has no such form.
+func (e *msgpackEncoder) writeHeader(n int, fix byte, fixMax int, m8, m16, m32 byte) {
+	switch {
+	case n < fixMax:
+		e.w.WriteByte(fix | byte(n))
+	case n <= math.MaxUint8 && m8 != 0:
+		e.w.WriteByte(m8)
+		e.w.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		e.w.WriteByte(m16)
+		e.writeBig(uint64(n), 2)
+	default:
+		e.w.WriteByte(m32)
+		e.writeBig(uint64(n), 4)
+	}
+}
+
+// writeBig writes the size low bytes of n in big endian order.
+func (e *msgpackEncoder) writeBig(n uint64, size int) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	e.w.Write(b[8-size:])
+}
@@ -1,6 +1,7 @@
 package next
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
@@ -13,6 +14,8 @@ import (
 type Mysql struct {
 	Db *sql.DB
 	Tx *sql.Tx
+	// ctx bounds the calls, see WithContext
+	ctx context.Context
 }
 
 func NewMysql() *Mysql {
@@ -29,8 +32,27 @@ func (mysql *Mysql) Open(dsn string) {
 	runtime.SetFinalizer(db, nil)
 }
 
+// WithContext returns a copy of mysql whose calls give up when ctx is
+// done, as in mysql.WithContext(ctx.Context()) for the calls of a request.
+// The copy shares the connections, and the transaction open when it is
+// made, if any. Begin, Commit and Rollback only change the Tx of the
+// value they are called on: a transaction is to be opened and closed on
+// the same value. One begun on the copy is rolled back when ctx is done.
+func (mysql *Mysql) WithContext(ctx context.Context) *Mysql {
+	m := *mysql
+	m.ctx = ctx
+	return &m
+}
+
+func (mysql *Mysql) context() context.Context {
+	if mysql.ctx == nil {
+		return context.Background()
+	}
+	return mysql.ctx
+}
+
 func (mysql *Mysql) Ping() error {
-	return mysql.Db.Ping()
+	return mysql.Db.PingContext(mysql.context())
 }
 
 func (mysql *Mysql) Close() {
@@ -47,9 +69,9 @@ func (mysql *Mysql) Query(args ...interface{}) ([]interface{}, error) {
 	var stmt *sql.Stmt
 	// Prepare
 	if mysql.Tx != nil {
-		stmt, err = mysql.Tx.Prepare(q)
+		stmt, err = mysql.Tx.PrepareContext(mysql.context(), q)
 	} else {
-		stmt, err = mysql.Db.Prepare(q)
+		stmt, err = mysql.Db.PrepareContext(mysql.context(), q)
 	}
 	if err != nil {
 		return nil, err
@@ -59,7 +81,7 @@ func (mysql *Mysql) Query(args ...interface{}) ([]interface{}, error) {
 	// -------------
 	// Scan
 	// -------------
-	rows, err := stmt.Query(param...)
+	rows, err := stmt.QueryContext(mysql.context(), param...)
 	if err != nil {
 		return nil, err
 	}
@@ -105,9 +127,9 @@ func (mysql *Mysql) Row(args ...interface{}) (interface{}, error) {
 	var stmt *sql.Stmt
 	// Prepare
 	if mysql.Tx != nil {
-		stmt, err = mysql.Tx.Prepare(q)
+		stmt, err = mysql.Tx.PrepareContext(mysql.context(), q)
 	} else {
-		stmt, err = mysql.Db.Prepare(q)
+		stmt, err = mysql.Db.PrepareContext(mysql.context(), q)
 	}
 	if err != nil {
 		return nil, err
@@ -117,7 +139,7 @@ func (mysql *Mysql) Row(args ...interface{}) (interface{}, error) {
 	// -------------
 	// Scan
 	// -------------
-	rows, err := stmt.Query(param...)
+	rows, err := stmt.QueryContext(mysql.context(), param...)
 	if err != nil {
 		return nil, err
 	}
@@ -175,7 +197,7 @@ func (mysql *Mysql) Exec(table, dml string, args ...interface{}) (interface{}, e
 }
 
 func (mysql *Mysql) Begin() {
-	tx, err := mysql.Db.Begin()
+	tx, err := mysql.Db.BeginTx(mysql.context(), nil)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -257,16 +279,16 @@ func (mysql *Mysql) insert(table string, args ...interface{}) (interface{}, erro
 	var err error
 	// Prepare
 	if mysql.Tx != nil {
-		stmt, err = mysql.Tx.Prepare(q)
+		stmt, err = mysql.Tx.PrepareContext(mysql.context(), q)
 	} else {
-		stmt, err = mysql.Db.Prepare(q)
+		stmt, err = mysql.Db.PrepareContext(mysql.context(), q)
 	}
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Exec(param...)
+	res, err := stmt.ExecContext(mysql.context(), param...)
 	if err != nil {
 		return nil, err
 	}
@@ -320,16 +342,16 @@ func (mysql *Mysql) update(table string, args ...interface{}) (interface{}, erro
 	var err error
 	// Prepare
 	if mysql.Tx != nil {
-		stmt, err = mysql.Tx.Prepare(q)
+		stmt, err = mysql.Tx.PrepareContext(mysql.context(), q)
 	} else {
-		stmt, err = mysql.Db.Prepare(q)
+		stmt, err = mysql.Db.PrepareContext(mysql.context(), q)
 	}
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Exec(param...)
+	res, err := stmt.ExecContext(mysql.context(), param...)
 	if err != nil {
 		return nil, err
 	}
@@ -363,16 +385,16 @@ func (mysql *Mysql) del(table string, args ...interface{}) (interface{}, error)
 	var err error
 	// Prepare
 	if mysql.Tx != nil {
-		stmt, err = mysql.Tx.Prepare(q)
+		stmt, err = mysql.Tx.PrepareContext(mysql.context(), q)
 	} else {
-		stmt, err = mysql.Db.Prepare(q)
+		stmt, err = mysql.Db.PrepareContext(mysql.context(), q)
 	}
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Exec(param...)
+	res, err := stmt.ExecContext(mysql.context(), param...)
 	if err != nil {
 		return nil, err
 	}
@@ -20,7 +20,9 @@
 package next
 
 import (
+	"context"
 	"crypto/tls"
+	"html/template"
 	"net/http"
 )
 
@@ -46,40 +48,71 @@ func Close() {
 	mainServer.Close()
 }
 
+// Shutdown stops the main server gracefully, see Server.Shutdown.
+func Shutdown(ctx context.Context) error {
+	return mainServer.Shutdown(ctx)
+}
+
 // Get adds a handler for the 'GET' http method in the main server.
-func Get(route string, handler interface{}) {
-	mainServer.Get(route, handler)
+func Get(route string, handler interface{}, middleware ...Middleware) *Route {
+	return mainServer.Get(route, handler, middleware...)
 }
 
 // Post adds a handler for the 'POST' http method in the main server.
-func Post(route string, handler interface{}) {
-	mainServer.Post(route, handler)
+func Post(route string, handler interface{}, middleware ...Middleware) *Route {
+	return mainServer.Post(route, handler, middleware...)
 }
 
 // Post adds a handler for the 'POST' http method in the main server.
-func Via(route string, handler interface{}) {
-	mainServer.Get(route, handler)
-	mainServer.Post(route, handler)
+func Via(route string, handler interface{}, middleware ...Middleware) {
+	mainServer.Get(route, handler, middleware...)
+	mainServer.Post(route, handler, middleware...)
 }
 
 // Put adds a handler for the 'PUT' http method in the main server.
-func Put(route string, handler interface{}) {
-	mainServer.Put(route, handler)
+func Put(route string, handler interface{}, middleware ...Middleware) *Route {
+	return mainServer.Put(route, handler, middleware...)
 }
 
 // Delete adds a handler for the 'DELETE' http method in the main server.
-func Delete(route string, handler interface{}) {
-	mainServer.Delete(route, handler)
+func Delete(route string, handler interface{}, middleware ...Middleware) *Route {
+	return mainServer.Delete(route, handler, middleware...)
 }
 
 // Match adds a handler for an arbitrary http method in the main server.
-func Match(method string, route string, handler interface{}) {
-	mainServer.Match(route, method, handler)
+func Match(method string, route string, handler interface{}, middleware ...Middleware) *Route {
+	return mainServer.Match(route, method, handler, middleware...)
 }
 
 // Adds a custom handler. Only for webserver mode. Will have no effect when running as FCGI or SCGI.
-func Handler(route string, method string, httpHandler http.Handler) {
-	mainServer.Handler(route, method, httpHandler)
+func Handler(route string, method string, httpHandler http.Handler, middleware ...Middleware) *Route {
+	return mainServer.Handler(route, method, httpHandler, middleware...)
+}
+
+// Use adds middleware that runs for every request of the main server.
+func Use(middleware ...Middleware) {
+	mainServer.Use(middleware...)
+}
+
+// Mount registers the routes of group g in the main server under prefix.
+func Mount(prefix string, g *Group, middleware ...Middleware) {
+	mainServer.Mount(prefix, g, middleware...)
+}
+
+// Static serves the files of dir under prefix in the main server.
+func Static(prefix, dir string, opts ...StaticOptions) *Route {
+	return mainServer.Static(prefix, dir, opts...)
+}
+
+// TemplateFuncs adds funcs to the functions of the templates of the main
+// server.
+func TemplateFuncs(funcs template.FuncMap) {
+	mainServer.TemplateFuncs(funcs)
+}
+
+// SetSessionStore sets the store of the sessions of the main server.
+func SetSessionStore(store SessionStore) {
+	mainServer.SetSessionStore(store)
 }
 
 // Default server
@@ -94,6 +127,11 @@ func RunTcp(addr string) {
 	mainTcp.Run(addr)
 }
 
+// ShutdownTcp stops the main Tcp server gracefully, see Tcp.Shutdown.
+func ShutdownTcp(ctx context.Context) error {
+	return mainTcp.Shutdown(ctx)
+}
+
 // Add a handler for tcp method in the main server.
 func ViaTcp(route string, handler interface{}) {
 	mainTcp.Via(route, handler)
@@ -117,6 +155,11 @@ func RunDuo(addr string) {
 	mainDuo.Run(addr)
 }
 
+// ShutdownDuo stops the main Duo server gracefully, see Duo.Shutdown.
+func ShutdownDuo(ctx context.Context) error {
+	return mainDuo.Shutdown(ctx)
+}
+
 // Add a handler for tcp method in the main server.
 func ViaDuo(route string, handler interface{}) {
 	mainDuo.Via(route, handler)
@@ -1,6 +1,7 @@
 package next
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/nsqio/go-nsq"
 )
@@ -59,3 +60,31 @@ func (n *Nsq) Subscribe(topicName, channelName string, hander nsq.HandlerFunc) e
 	<-r.StopChan
 	return nil
 }
+
+// PublishRequest publishes message to a topic on behalf of the request of
+// ID reqID. A message holding a JSON object gets the ID in its request_id
+// field, unless it has one; consumers read it with MessageRequestID.
+func (n *Nsq) PublishRequest(reqID, topicName string, message []byte) error {
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(message, &obj) == nil && obj != nil {
+		if _, ok := obj["request_id"]; !ok {
+			obj["request_id"], _ = json.Marshal(reqID)
+			if b, err := json.Marshal(obj); err == nil {
+				message = b
+			}
+		}
+	}
+	return n.Publish(topicName, message)
+}
+
+// MessageRequestID returns the request ID carried by the body of a
+// message published with PublishRequest, or "".
+func MessageRequestID(body []byte) string {
+	var msg struct {
+		RequestID string `json:"request_id"`
+	}
+	if json.Unmarshal(body, &msg) != nil {
+		return ""
+	}
+	return msg.RequestID
+}
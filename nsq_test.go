@@ -0,0 +1,15 @@
+package next
+
+import "testing"
+
+func TestMessageRequestID(t *testing.T) {
+	for _, c := range []struct{ body, want string }{
+		{`{"request_id": "abc"}`, "abc"},
+		{`{"user": 1}`, ""},
+		{`plain`, ""},
+	} {
+		if got := MessageRequestID([]byte(c.body)); got != c.want {
+			t.Errorf("MessageRequestID(%s) = %q, want %q", c.body, got, c.want)
+		}
+	}
+}
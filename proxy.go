@@ -0,0 +1,254 @@
+package next
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// proxies is the parsed proxy.trusted setting of a server.
+type proxies struct {
+	mu   sync.Mutex
+	conf string
+	nets []*net.IPNet
+}
+
+// trustedProxies returns the networks of the proxy.trusted setting, a list
+// of addresses and CIDR ranges: ["10.0.0.0/8", "127.0.0.1"].
+func (s *Server) trustedProxies() []*net.IPNet {
+	list := s.Config.Strings("proxy.trusted")
+	conf := strings.Join(list, ",")
+
+	s.proxies.mu.Lock()
+	defer s.proxies.mu.Unlock()
+	if conf == s.proxies.conf {
+		return s.proxies.nets
+	}
+
+	var nets []*net.IPNet
+	for _, p := range list {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			s.Logger.Println("Invalid trusted proxy", p)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	s.proxies.conf, s.proxies.nets = conf, nets
+	return nets
+}
+
+func (s *Server) isTrustedProxy(ip net.IP) bool {
+	for _, n := range s.trustedProxies() {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIp returns the IP address of the client. When the request comes
+// from a trusted proxy, see the proxy.trusted setting, the address is the
+// last one of the Forwarded or X-Forwarded-For header not of a trusted
+// proxy, or the one of X-Real-IP.
+func (ctx *Context) ClientIp() (string, error) {
+	host, _, err := net.SplitHostPort(ctx.Request.RemoteAddr)
+	if err != nil {
+		return "", fmt.Errorf("userip: %q is not IP:port", ctx.Request.RemoteAddr)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", fmt.Errorf("userip: %q is not IP:port", ctx.Request.RemoteAddr)
+	}
+	if !ctx.Server.isTrustedProxy(ip) {
+		return ip.String(), nil
+	}
+
+	var hops []string
+	h := ctx.Request.Header
+	if fwd := h.Values("Forwarded"); len(fwd) > 0 {
+		for _, elem := range parseForwarded(fwd) {
+			hops = append(hops, elem["for"])
+		}
+	} else {
+		for _, xff := range h.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(xff, ",")...)
+		}
+	}
+	if len(hops) == 0 {
+		if real := net.ParseIP(strings.TrimSpace(h.Get("X-Real-IP"))); real != nil {
+			return real.String(), nil
+		}
+	}
+
+	// each proxy appends the address it got the request from: walk back
+	// until an address not of a trusted proxy
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseNode(hops[i])
+		if hop == nil {
+			// unknown or obfuscated, as in for=unknown
+			break
+		}
+		ip = hop
+		if !ctx.Server.isTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip.String(), nil
+}
+
+// Scheme returns "https" or "http": the scheme of the request to a trusted
+// proxy according to the Forwarded or X-Forwarded-Proto header, or the
+// scheme of the request itself.
+func (ctx *Context) Scheme() string {
+	if ctx.fromTrustedProxy() {
+		if proto := ctx.forwarded("proto", "X-Forwarded-Proto"); proto != "" {
+			return strings.ToLower(proto)
+		}
+	}
+	if ctx.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Host returns the host the client asked for: from the Forwarded or
+// X-Forwarded-Host header of a trusted proxy, or the Host header.
+func (ctx *Context) Host() string {
+	if ctx.fromTrustedProxy() {
+		if host := ctx.forwarded("host", "X-Forwarded-Host"); host != "" {
+			return host
+		}
+	}
+	return ctx.Request.Host
+}
+
+// forwarded returns the param of the Forwarded header, or else the value
+// of the X-Forwarded header, set by the trusted proxy the client called.
+// The proxies append to the headers: the values at the start are written
+// by the client and not to be trusted. Like ClientIp, it walks back the
+// hops as long as they are trusted proxies.
+func (ctx *Context) forwarded(param, header string) string {
+	h := ctx.Request.Header
+	if fwd := h.Values("Forwarded"); len(fwd) > 0 {
+		elems := parseForwarded(fwd)
+		val := ""
+		for i := len(elems) - 1; i >= 0; i-- {
+			// elems[i] is added by a trusted proxy
+			if v := elems[i][param]; v != "" {
+				val = v
+			}
+			hop := parseNode(elems[i]["for"])
+			if hop == nil || !ctx.Server.isTrustedProxy(hop) {
+				break
+			}
+		}
+		if val != "" {
+			return val
+		}
+	}
+
+	var vals, hops []string
+	for _, v := range h.Values(header) {
+		vals = append(vals, strings.Split(v, ",")...)
+	}
+	if len(vals) == 0 {
+		return ""
+	}
+	for _, xff := range h.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(xff, ",")...)
+	}
+	// the trusted proxies added the last n values
+	n := 1
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseNode(hops[i])
+		if hop == nil || !ctx.Server.isTrustedProxy(hop) {
+			break
+		}
+		n++
+	}
+	if n > len(vals) {
+		// proxies replacing the header rather than appending to it
+		n = len(vals)
+	}
+	return strings.TrimSpace(vals[len(vals)-n])
+}
+
+func (ctx *Context) fromTrustedProxy() bool {
+	host, _, err := net.SplitHostPort(ctx.Request.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ctx.Server.isTrustedProxy(ip)
+}
+
+// parseForwarded returns the elements of RFC 7239 Forwarded headers, the
+// parameters of each by lower-case name:
+//
+//	Forwarded: for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"
+func parseForwarded(headers []string) []map[string]string {
+	var elems []map[string]string
+	for _, header := range headers {
+		for _, elem := range splitQuoted(header, ',') {
+			params := map[string]string{}
+			for _, pair := range splitQuoted(elem, ';') {
+				i := strings.IndexByte(pair, '=')
+				if i < 0 {
+					continue
+				}
+				key := strings.ToLower(strings.TrimSpace(pair[:i]))
+				val := strings.TrimSpace(pair[i+1:])
+				if len(val) >= 2 && val[0] == '"' && val[len(val)-1] == '"' {
+					val = strings.Replace(val[1:len(val)-1], `\"`, `"`, -1)
+				}
+				params[key] = val
+			}
+			elems = append(elems, params)
+		}
+	}
+	if len(elems) == 0 {
+		elems = append(elems, map[string]string{})
+	}
+	return elems
+}
+
+// splitQuoted splits s at sep, out of quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseNode returns the IP of a node of X-Forwarded-For or of the for
+// parameter of Forwarded, with or without a port: "192.0.2.60",
+// "192.0.2.60:80", "[2001:db8::1]:4711" or "2001:db8::1".
+func parseNode(node string) net.IP {
+	node = strings.TrimSpace(node)
+	if ip := net.ParseIP(node); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.Trim(node, "[]"))
+}
@@ -0,0 +1,88 @@
+package next
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+)
+
+func proxyContext(remote string, header ...string) *Context {
+	s := newTestServer()
+	s.Config.Read([]byte(`{"proxy": {"trusted": ["10.0.0.0/8", "2001:db8::1"]}}`))
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = remote
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Add(header[i], header[i+1])
+	}
+	return &Context{Request: req, Server: s}
+}
+
+func TestClientIp(t *testing.T) {
+	for _, c := range []struct {
+		remote string
+		header []string
+		want   string
+	}{
+		{"192.0.2.1:1234", nil, "192.0.2.1"},
+		// headers of untrusted clients are ignored
+		{"192.0.2.1:1234", []string{"X-Forwarded-For", "1.2.3.4"}, "192.0.2.1"},
+		{"10.0.0.1:1234", []string{"X-Forwarded-For", "1.2.3.4"}, "1.2.3.4"},
+		// the spoofed first address is skipped
+		{"10.0.0.1:1234", []string{"X-Forwarded-For", "6.6.6.6, 1.2.3.4, 10.0.0.2"}, "1.2.3.4"},
+		{"10.0.0.1:1234", []string{"X-Forwarded-For", "6.6.6.6", "X-Forwarded-For", "1.2.3.4"}, "1.2.3.4"},
+		{"10.0.0.1:1234", []string{"X-Real-IP", "1.2.3.4"}, "1.2.3.4"},
+		{"10.0.0.1:1234", []string{"Forwarded", `for=6.6.6.6, for="[2001:db8::2]:4711";proto=https`}, "2001:db8::2"},
+		{"[2001:db8::1]:80", []string{"Forwarded", `for=1.2.3.4:80;by=10.0.0.1`}, "1.2.3.4"},
+		{"10.0.0.1:1234", []string{"Forwarded", `for=unknown`}, "10.0.0.1"},
+	} {
+		got, err := proxyContext(c.remote, c.header...).ClientIp()
+		if err != nil || got != c.want {
+			t.Errorf("%s %v: got %s, %v, want %s", c.remote, c.header, got, err, c.want)
+		}
+	}
+}
+
+func TestSchemeHost(t *testing.T) {
+	ctx := proxyContext("10.0.0.1:1234", "X-Forwarded-Proto", "https", "X-Forwarded-Host", "api.example.com")
+	if ctx.Scheme() != "https" || ctx.Host() != "api.example.com" {
+		t.Errorf("x-forwarded: %s %s", ctx.Scheme(), ctx.Host())
+	}
+	ctx = proxyContext("10.0.0.1:1234", "Forwarded", `proto=https;host="a.example.com:8443"`)
+	if ctx.Scheme() != "https" || ctx.Host() != "a.example.com:8443" {
+		t.Errorf("forwarded: %s %s", ctx.Scheme(), ctx.Host())
+	}
+	// the leftmost values are the client's own
+	ctx = proxyContext("10.0.0.1:1234", "Forwarded", "proto=https;host=evil",
+		"Forwarded", "for=192.0.2.60;proto=http;host=example.org")
+	if ctx.Scheme() != "http" || ctx.Host() != "example.org" {
+		t.Errorf("faked forwarded: %s %s", ctx.Scheme(), ctx.Host())
+	}
+	ctx = proxyContext("10.0.0.1:1234", "Forwarded", "proto=https;host=evil, for=192.0.2.60")
+	if ctx.Scheme() != "http" || ctx.Host() != "example.com" {
+		t.Errorf("faked forwarded, none added: %s %s", ctx.Scheme(), ctx.Host())
+	}
+	ctx = proxyContext("10.0.0.1:1234", "X-Forwarded-For", "192.0.2.60",
+		"X-Forwarded-Proto", "https, http", "X-Forwarded-Host", "evil, example.org")
+	if ctx.Scheme() != "http" || ctx.Host() != "example.org" {
+		t.Errorf("faked x-forwarded: %s %s", ctx.Scheme(), ctx.Host())
+	}
+	// through two trusted proxies, the first one tells
+	ctx = proxyContext("10.0.0.1:1234",
+		"Forwarded", "for=192.0.2.60;proto=https;host=shop.example.com, for=10.0.0.2;proto=http;host=internal")
+	if ctx.Scheme() != "https" || ctx.Host() != "shop.example.com" {
+		t.Errorf("chained forwarded: %s %s", ctx.Scheme(), ctx.Host())
+	}
+	ctx = proxyContext("10.0.0.1:1234", "X-Forwarded-For", "192.0.2.60, 10.0.0.2",
+		"X-Forwarded-Proto", "https, http", "X-Forwarded-Host", "shop.example.com, internal")
+	if ctx.Scheme() != "https" || ctx.Host() != "shop.example.com" {
+		t.Errorf("chained x-forwarded: %s %s", ctx.Scheme(), ctx.Host())
+	}
+	ctx = proxyContext("192.0.2.1:1234", "X-Forwarded-Proto", "https", "X-Forwarded-Host", "evil.com")
+	if ctx.Scheme() != "http" || ctx.Host() != "example.com" {
+		t.Errorf("untrusted: %s %s", ctx.Scheme(), ctx.Host())
+	}
+	ctx.Request.TLS = &tls.ConnectionState{}
+	if ctx.Scheme() != "https" {
+		t.Errorf("tls: %s", ctx.Scheme())
+	}
+}
@@ -0,0 +1,363 @@
+package next
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Rate limiting algorithms.
+const (
+	// TokenBucket allows bursts of Requests, refilled evenly over Period.
+	TokenBucket = iota
+	// SlidingWindow allows Requests over any Period, weighing the count of
+	// the previous period by how much of it is still in the window.
+	SlidingWindow
+)
+
+// A Limit is a number of requests allowed per period.
+type Limit struct {
+	Requests  int
+	Period    time.Duration
+	Algorithm int
+}
+
+func (l Limit) validate() error {
+	if l.Requests <= 0 || l.Period <= 0 {
+		return fmt.Errorf("next: rate limit of %d requests per %v", l.Requests, l.Period)
+	}
+	if l.Algorithm != TokenBucket && l.Algorithm != SlidingWindow {
+		return fmt.Errorf("next: rate limit algorithm %d", l.Algorithm)
+	}
+	return nil
+}
+
+// A LimitResult is the answer of a LimitStore for a request.
+type LimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the limit is whole again.
+	Reset time.Duration
+	// RetryAfter is the time until a request is allowed, when it is not.
+	RetryAfter time.Duration
+}
+
+// A LimitStore counts the requests of each key.
+type LimitStore interface {
+	Allow(key string, limit Limit) (LimitResult, error)
+}
+
+// tokenBucket computes the state of a bucket holding tokens at last, at
+// now. It returns the tokens left and the result.
+func tokenBucket(limit Limit, tokens float64, last, now time.Time) (float64, LimitResult) {
+	capacity := float64(limit.Requests)
+	rate := capacity / float64(limit.Period)
+	tokens = math.Min(capacity, tokens+float64(now.Sub(last))*rate)
+
+	res := LimitResult{Limit: limit.Requests}
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - tokens) / rate)
+	}
+	res.Remaining = int(tokens)
+	res.Reset = time.Duration((capacity - tokens) / rate)
+	return tokens, res
+}
+
+// slidingWindow returns the result of a request at now, given the counts
+// of the current and previous fixed windows.
+func slidingWindow(limit Limit, prev, cur int, now time.Time) LimitResult {
+	elapsed := time.Duration(now.UnixNano() % int64(limit.Period))
+	weight := 1 - float64(elapsed)/float64(limit.Period)
+	count := float64(prev)*weight + float64(cur)
+
+	res := LimitResult{Limit: limit.Requests, Reset: limit.Period - elapsed}
+	if count+1 <= float64(limit.Requests) {
+		res.Allowed = true
+		count++
+	} else if left := float64(limit.Requests - 1 - cur); prev > 0 && left >= 0 {
+		// wait for the previous window to weigh little enough
+		at := time.Duration((1 - left/float64(prev)) * float64(limit.Period))
+		res.RetryAfter = at - elapsed
+	} else {
+		res.RetryAfter = limit.Period - elapsed
+	}
+	res.Remaining = limit.Requests - int(math.Ceil(count))
+	if res.Remaining < 0 {
+		res.Remaining = 0
+	}
+	return res
+}
+
+func windowOf(limit Limit, now time.Time) int64 {
+	return now.UnixNano() / int64(limit.Period)
+}
+
+// MemoryLimitStore counts requests in memory, for a single process.
+type MemoryLimitStore struct {
+	mu        sync.Mutex
+	entries   map[string]*limitEntry
+	nextSweep time.Time
+}
+
+type limitEntry struct {
+	// token bucket
+	tokens float64
+	last   time.Time
+	// sliding window
+	window    int64
+	prev, cur int
+
+	expires time.Time
+}
+
+func NewMemoryLimitStore() *MemoryLimitStore {
+	return &MemoryLimitStore{entries: map[string]*limitEntry{}}
+}
+
+func (m *MemoryLimitStore) Allow(key string, limit Limit) (LimitResult, error) {
+	if err := limit.validate(); err != nil {
+		return LimitResult{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.sweep(now)
+
+	e := m.entries[key]
+	if e == nil {
+		e = &limitEntry{tokens: float64(limit.Requests), last: now, window: windowOf(limit, now)}
+		m.entries[key] = e
+	}
+	e.expires = now.Add(2 * limit.Period)
+
+	if limit.Algorithm == TokenBucket {
+		var res LimitResult
+		e.tokens, res = tokenBucket(limit, e.tokens, e.last, now)
+		e.last = now
+		return res, nil
+	}
+
+	switch w := windowOf(limit, now); w {
+	case e.window:
+	case e.window + 1:
+		e.window, e.prev, e.cur = w, e.cur, 0
+	default:
+		e.window, e.prev, e.cur = w, 0, 0
+	}
+	res := slidingWindow(limit, e.prev, e.cur, now)
+	if res.Allowed {
+		e.cur++
+	}
+	return res, nil
+}
+
+// sweep removes the entries unused for long, once a minute.
+func (m *MemoryLimitStore) sweep(now time.Time) {
+	if now.Before(m.nextSweep) {
+		return
+	}
+	m.nextSweep = now.Add(time.Minute)
+	for key, e := range m.entries {
+		if now.After(e.expires) {
+			delete(m.entries, key)
+		}
+	}
+}
+
+// RedisLimitStore counts requests in Redis, so that processes share the
+// limits. The clocks of the processes are to be in sync.
+type RedisLimitStore struct {
+	redis  *Redis
+	prefix string
+}
+
+// NewRedisLimitStore returns a store counting requests in r, under keys
+// starting with prefix.
+func NewRedisLimitStore(r *Redis, prefix string) *RedisLimitStore {
+	return &RedisLimitStore{redis: r, prefix: prefix}
+}
+
+// tokenBucketScript updates the bucket of KEYS[1], of ARGV[1] tokens
+// refilled by ARGV[2] per millisecond, at ARGV[3] milliseconds. It
+// returns whether the request is allowed and the tokens left, in
+// thousandths.
+const tokenBucketScript = `
+local capacity, rate, now = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(b[1]) or capacity
+local last = tonumber(b[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - last) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tokens, 'last', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) / rate) + 1000)
+return {allowed, math.floor(tokens * 1000)}
+`
+
+// slidingWindowScript counts a request in the window of KEYS[1], of
+// ARGV[1] requests per ARGV[2] milliseconds, unless the weighted count
+// with the previous window KEYS[2], ARGV[3] of whose weight is left in
+// thousandths, reaches the limit. It returns whether the request is
+// allowed and the counts of both windows.
+const slidingWindowScript = `
+local limit, period, weight = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]) / 1000
+local cur = tonumber(redis.call('GET', KEYS[1]) or '0')
+local prev = tonumber(redis.call('GET', KEYS[2]) or '0')
+if prev * weight + cur + 1 > limit then
+	return {0, prev, cur}
+end
+redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], period * 2)
+return {1, prev, cur}
+`
+
+func (r *RedisLimitStore) Allow(key string, limit Limit) (LimitResult, error) {
+	if err := limit.validate(); err != nil {
+		return LimitResult{}, err
+	}
+	now := time.Now()
+	ms := int64(limit.Period / time.Millisecond)
+	if ms <= 0 {
+		return LimitResult{}, fmt.Errorf("next: rate limit period %v under a millisecond", limit.Period)
+	}
+
+	if limit.Algorithm == TokenBucket {
+		rate := float64(limit.Requests) / float64(ms)
+		vals, err := redis.Int64s(r.redis.Do("EVAL", tokenBucketScript, 1, r.prefix+key,
+			limit.Requests, strconv.FormatFloat(rate, 'g', -1, 64), now.UnixNano()/int64(time.Millisecond)))
+		if err != nil {
+			return LimitResult{}, err
+		}
+		// the bucket is already updated: recompute the result from the
+		// tokens left, before the request took one if it did
+		tokens := float64(vals[1]) / 1000
+		if vals[0] == 1 {
+			tokens++
+		}
+		_, res := tokenBucket(limit, tokens, now, now)
+		return res, nil
+	}
+
+	w := windowOf(limit, now)
+	elapsed := now.UnixNano() % int64(limit.Period)
+	weight := 1000 - elapsed*1000/int64(limit.Period)
+	cur := fmt.Sprintf("%s%s:%d", r.prefix, key, w)
+	prev := fmt.Sprintf("%s%s:%d", r.prefix, key, w-1)
+	vals, err := redis.Ints(r.redis.Do("EVAL", slidingWindowScript, 2, cur, prev, limit.Requests, ms, weight))
+	if err != nil {
+		return LimitResult{}, err
+	}
+	res := slidingWindow(limit, vals[1], vals[2], now)
+	// the script decides, with a weight rounded to thousandths
+	res.Allowed = vals[0] == 1
+	return res, nil
+}
+
+// KeyByIP keys the requests of a rate limit by client IP, or by remote
+// address when the IP cannot be told.
+func KeyByIP(ctx *Context) string {
+	ip, err := ctx.ClientIp()
+	if err != nil {
+		// not in a bucket shared by every such client
+		return "addr:" + ctx.Request.RemoteAddr
+	}
+	return "ip:" + ip
+}
+
+// KeyByUser keys the requests of a rate limit by the user returned by
+// user, and the anonymous ones by client IP.
+func KeyByUser(user func(ctx *Context) string) func(ctx *Context) string {
+	return func(ctx *Context) string {
+		if u := user(ctx); u != "" {
+			return "user:" + u
+		}
+		return KeyByIP(ctx)
+	}
+}
+
+// RateLimit returns middleware allowing limit requests for each key, by
+// client IP when key is nil. Name sets apart the counts of limiters
+// sharing a store. The responses carry X-RateLimit-Limit,
+// X-RateLimit-Remaining and X-RateLimit-Reset, in seconds; requests over
+// the limit are answered with 429 Too Many Requests and Retry-After. When
+// the store fails, requests are let through. It panics on a limit without
+// requests or period.
+//
+//	login := next.RateLimit("login", store, next.Limit{Requests: 5, Period: time.Minute}, nil)
+//	s.Post("/login", handleLogin, login)
+func RateLimit(name string, store LimitStore, limit Limit, key func(ctx *Context) string) Middleware {
+	if err := limit.validate(); err != nil {
+		panic(err)
+	}
+	if key == nil {
+		key = KeyByIP
+	}
+	return func(ctx *Context) {
+		res, err := store.Allow(name+":"+key(ctx), limit)
+		if err != nil {
+			ctx.Server.Logger.Println("Rate limit failed", err)
+			return
+		}
+
+		ctx.SetHeader("X-RateLimit-Limit", strconv.Itoa(res.Limit), true)
+		ctx.SetHeader("X-RateLimit-Remaining", strconv.Itoa(res.Remaining), true)
+		ctx.SetHeader("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)), true)
+		if !res.Allowed {
+			ctx.SetHeader("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)), true)
+			ctx.Abort(429, "Too Many Requests")
+		}
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+type tcpLimit struct {
+	store LimitStore
+	limit Limit
+}
+
+// Limit allows limit calls of method per connection, "*" standing for
+// all the methods. Calls over the limit are answered with code 429 and
+// the seconds to wait in retry_after. It panics on a limit without
+// requests or period.
+func (t *Tcp) Limit(method string, store LimitStore, limit Limit) {
+	if err := limit.validate(); err != nil {
+		panic(err)
+	}
+	t.limits[method] = tcpLimit{store, limit}
+}
+
+// allow reports whether the call of ctx is within the limits of t,
+// answering it with 429 when it is not.
+func (t *Tcp) allow(ctx *TcpContext) bool {
+	for _, method := range []string{"*", ctx.Method} {
+		l, ok := t.limits[method]
+		if !ok {
+			continue
+		}
+		res, err := l.store.Allow("tcp:"+method+":"+ctx.Fd, l.limit)
+		if err != nil {
+			t.Logger.Println("Rate limit failed", err)
+			continue
+		}
+		if !res.Allowed {
+			ctx.WriteJSON("429", "too many requests", map[string]int{"retry_after": ceilSeconds(res.RetryAfter)})
+			return false
+		}
+	}
+	return true
+}
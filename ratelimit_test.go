@@ -0,0 +1,121 @@
+package next
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimitStore(t *testing.T) {
+	for _, algo := range []int{TokenBucket, SlidingWindow} {
+		store := NewMemoryLimitStore()
+		limit := Limit{Requests: 3, Period: time.Hour, Algorithm: algo}
+		for i := 0; i < 3; i++ {
+			if res, _ := store.Allow("a", limit); !res.Allowed || res.Remaining != 2-i {
+				t.Errorf("algorithm %d: request %d: %+v", algo, i, res)
+			}
+		}
+		res, _ := store.Allow("a", limit)
+		if res.Allowed || res.RetryAfter <= 0 || res.RetryAfter > time.Hour {
+			t.Errorf("algorithm %d: over the limit: %+v", algo, res)
+		}
+		if res, _ := store.Allow("b", limit); !res.Allowed {
+			t.Errorf("algorithm %d: other key: %+v", algo, res)
+		}
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	limit := Limit{Requests: 10, Period: 10 * time.Second}
+	now := time.Now()
+	tokens, res := tokenBucket(limit, 0, now.Add(-1500*time.Millisecond), now)
+	if !res.Allowed || tokens < 0.49 || tokens > 0.51 {
+		t.Errorf("refilled to %v, %+v", tokens, res)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	limit := Limit{Requests: 10, Period: time.Minute, Algorithm: SlidingWindow}
+	// a quarter into the window, 3/4 of the previous one still counts
+	now := time.Unix(0, 0).Add(100*time.Minute + 15*time.Second)
+	if res := slidingWindow(limit, 8, 3, now); !res.Allowed || res.Remaining != 0 {
+		t.Errorf("9+1 requests: %+v", res)
+	}
+	res := slidingWindow(limit, 8, 4, now)
+	if res.Allowed || res.RetryAfter != 7500*time.Millisecond {
+		t.Errorf("10+1 requests: %+v", res)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := newTestServer()
+	limit := RateLimit("api", NewMemoryLimitStore(), Limit{Requests: 2, Period: time.Minute}, nil)
+	s.Get("/", func() string { return "ok" }, limit)
+
+	serve(s, "GET", "/")
+	w := serve(s, "GET", "/")
+	if w.Code != 200 || w.Header().Get("X-RateLimit-Remaining") != "0" || w.Header().Get("X-RateLimit-Limit") != "2" {
+		t.Errorf("within limit: %d %v", w.Code, w.Header())
+	}
+	w = serve(s, "GET", "/")
+	if w.Code != 429 || w.Header().Get("Retry-After") != "30" {
+		t.Errorf("over limit: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestBadLimit(t *testing.T) {
+	for _, limit := range []Limit{
+		{Requests: 5, Algorithm: SlidingWindow},
+		{Period: time.Minute},
+		{Requests: 5, Period: time.Minute, Algorithm: 7},
+	} {
+		if _, err := NewMemoryLimitStore().Allow("a", limit); err == nil {
+			t.Errorf("%+v: allowed", limit)
+		}
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%+v: RateLimit did not panic", limit)
+				}
+			}()
+			RateLimit("api", NewMemoryLimitStore(), limit, nil)
+		}()
+	}
+}
+
+func TestKeyByIP(t *testing.T) {
+	s := newTestServer()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "@unix"
+	ctx := &Context{Request: req, Server: s}
+	if key := KeyByIP(ctx); key != "addr:@unix" {
+		t.Errorf("got %q", key)
+	}
+}
+
+func TestTcpLimit(t *testing.T) {
+	tcp := NewTcp()
+	tcp.Logger.SetOutput(ioutil.Discard)
+	tcp.Via("ping", func(ctx *TcpContext) {
+		ctx.WriteJSON("200", "pong")
+	})
+	tcp.Limit("ping", NewMemoryLimitStore(), Limit{Requests: 1, Period: time.Minute})
+
+	client := pipeTcp(t, tcp)
+	defer client.Close()
+	r := bufio.NewReader(client)
+	for _, want := range []string{"200", "429"} {
+		tcp.Pack(client, []byte(`{"method": "ping", "seq": "1"}`))
+		body, err := tcp.Unpack(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json := NewJson()
+		json.Load(body)
+		if code := json.Get("code").MustString(); code != want {
+			t.Errorf("code = %s, want %s: %s", code, want, body)
+		}
+	}
+}
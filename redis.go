@@ -1,12 +1,15 @@
 package next
 
 import (
+	"context"
 	"github.com/garyburd/redigo/redis"
 	"time"
 )
 
 type Redis struct {
 	pool *redis.Pool
+	// ctx bounds the calls, see WithContext
+	ctx context.Context
 }
 
 func NewRedis() *Redis {
@@ -37,10 +40,36 @@ func (r *Redis) Pool(server string, pwd ...interface{}) {
 	}
 }
 
+// WithContext returns a copy of r whose calls give up when ctx is done,
+// as in r.WithContext(ctx.Context()) for the calls of a request. The copy
+// shares the pool of connections.
+func (r *Redis) WithContext(ctx context.Context) *Redis {
+	c := *r
+	c.ctx = ctx
+	return &c
+}
+
 func (r *Redis) Do(cmd string, args ...interface{}) (interface{}, error) {
-	conn := r.pool.Get()
+	if r.ctx == nil {
+		conn := r.pool.Get()
+		defer conn.Close()
+
+		return conn.Do(cmd, args...)
+	}
+
+	conn, err := r.pool.GetContext(r.ctx)
+	if err != nil {
+		return nil, err
+	}
 	defer conn.Close()
 
+	if err := r.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if deadline, ok := r.ctx.Deadline(); ok {
+		// a zero timeout would be none
+		return redis.DoWithTimeout(conn, timeLeft(deadline), cmd, args...)
+	}
 	return conn.Do(cmd, args...)
 }
 
@@ -0,0 +1,198 @@
+package next
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrNotAcceptable is returned by Render when no codec produces a media
+// type the client accepts.
+var ErrNotAcceptable = errors.New("next: no acceptable media type")
+
+// A Codec encodes values for Render.
+type Codec interface {
+	Encode(w io.Writer, v interface{}) error
+}
+
+// CodecFunc adapts a function to the Codec interface.
+type CodecFunc func(w io.Writer, v interface{}) error
+
+func (f CodecFunc) Encode(w io.Writer, v interface{}) error {
+	return f(w, v)
+}
+
+type codec struct {
+	mediaType string
+	Codec
+}
+
+func defaultCodecs() []codec {
+	return []codec{
+		{"application/json", CodecFunc(encodeJSON)},
+		{"application/xml", CodecFunc(encodeXML)},
+		{"text/xml", CodecFunc(encodeXML)},
+		{"application/msgpack", CodecFunc(encodeMsgpack)},
+		{"application/x-msgpack", CodecFunc(encodeMsgpack)},
+	}
+}
+
+// RegisterCodec sets the codec Render uses for mediaType, replacing the
+// one registered before if any. When the client accepts anything, the
+// first codec registered is used, which is JSON by default.
+func (s *Server) RegisterCodec(mediaType string, c Codec) {
+	for i := range s.codecs {
+		if s.codecs[i].mediaType == mediaType {
+			s.codecs[i].Codec = c
+			return
+		}
+	}
+	s.codecs = append(s.codecs, codec{mediaType, c})
+}
+
+// Render writes v with the given status, encoded for the media type the
+// client prefers according to its Accept header: JSON, XML, msgpack or any
+// codec registered with RegisterCodec. When none is acceptable, Render
+// aborts with 406 Not Acceptable and returns ErrNotAcceptable, which the
+// handler may return as is.
+func (ctx *Context) Render(status int, v interface{}) error {
+	c, ok := ctx.negotiate(ctx.Request.Header.Get("Accept"))
+	ctx.SetHeader("Vary", "Accept", false)
+	if !ok {
+		ctx.Abort(406, "Not Acceptable")
+		return ErrNotAcceptable
+	}
+
+	var buf bytes.Buffer
+	if err := c.Encode(&buf, v); err != nil {
+		return err
+	}
+
+	ctx.SetHeader("Content-Type", c.mediaType, true)
+	ctx.SetHeader("Content-Length", strconv.Itoa(buf.Len()), true)
+	if status != 0 {
+		ctx.ResponseWriter.WriteHeader(status)
+	}
+	_, err := ctx.ResponseWriter.Write(buf.Bytes())
+	return err
+}
+
+// negotiate returns the codec the client prefers: the one of highest
+// quality, given by the most specific media range of accept matching it,
+// then the first registered. A codec whose range has quality 0 is refused.
+func (ctx *Context) negotiate(accept string) (codec, bool) {
+	codecs := ctx.Server.codecs
+	if len(codecs) == 0 {
+		codecs = defaultCodecs()
+	}
+	if strings.TrimSpace(accept) == "" {
+		return codecs[0], true
+	}
+
+	ranges := parseAccept(accept)
+	var best codec
+	var bestQ float64
+	bestSpec := -1
+	for _, c := range codecs {
+		q, spec := -1.0, -1
+		for _, r := range ranges {
+			if r.match(c.mediaType) && (r.specificity() > spec || r.specificity() == spec && r.q > q) {
+				q, spec = r.q, r.specificity()
+			}
+		}
+		if q > bestQ || q == bestQ && q > 0 && spec > bestSpec {
+			best, bestQ, bestSpec = c, q, spec
+		}
+	}
+	return best, bestQ > 0
+}
+
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+func (r mediaRange) match(mediaType string) bool {
+	typ, subtype := mediaType, ""
+	if i := strings.IndexByte(mediaType, '/'); i >= 0 {
+		typ, subtype = mediaType[:i], mediaType[i+1:]
+	}
+	return (r.typ == "*" || r.typ == typ) && (r.subtype == "*" || r.subtype == subtype)
+}
+
+// specificity counts the parts of r that are not wildcards.
+func (r mediaRange) specificity() int {
+	n := 0
+	if r.typ != "*" {
+		n++
+	}
+	if r.subtype != "*" {
+		n++
+	}
+	return n
+}
+
+// parseAccept returns the media ranges of an Accept header. Ranges of
+// quality 0 are kept: they exclude the media types they match.
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mt := strings.ToLower(strings.TrimSpace(params[0]))
+		if mt == "" {
+			continue
+		}
+
+		r := mediaRange{typ: mt, subtype: "*", q: 1}
+		if i := strings.IndexByte(mt, '/'); i >= 0 {
+			r.typ, r.subtype = mt[:i], mt[i+1:]
+		}
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if q, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					r.q = q
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	out, err := (&Json{data: v}).Encode()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// encodeXML writes flat maps with Xml.ToXML, and anything else with
+// encoding/xml.
+func encodeXML(w io.Writer, v interface{}) error {
+	switch m := v.(type) {
+	case map[string]string:
+		return NewXml().ToXML(w, m)
+	case map[string]interface{}:
+		flat := make(map[string]string, len(m))
+		for k, val := range m {
+			rv := reflect.ValueOf(val)
+			switch rv.Kind() {
+			case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct, reflect.Ptr:
+				return fmt.Errorf("next: can not encode nested %s of %q to XML", rv.Kind(), k)
+			}
+			if val != nil {
+				flat[k] = fmt.Sprint(val)
+			}
+		}
+		return NewXml().ToXML(w, flat)
+	}
+	return xml.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,111 @@
+package next
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"testing"
+)
+
+func renderRequest(s *Server, accept string, v interface{}) *httptest.ResponseRecorder {
+	s.Get("/", func(ctx *Context) error {
+		return ctx.Render(200, v)
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+type device struct {
+	XMLName struct{} `json:"-" xml:"device"`
+	Serial  string   `json:"serial" xml:"serial"`
+	Online  bool     `json:"online,omitempty" xml:"online"`
+}
+
+func TestRenderNegotiation(t *testing.T) {
+	tests := []struct {
+		accept string
+		v      interface{}
+		ctype  string
+		body   string
+	}{
+		{"", device{Serial: "a1"}, "application/json", `{"serial":"a1"}`},
+		{"*/*", device{Serial: "a1"}, "application/json", `{"serial":"a1"}`},
+		{"text/html, application/xml;q=0.9, */*;q=0.1", device{Serial: "a1"}, "application/xml",
+			`<device><serial>a1</serial><online>false</online></device>`},
+		{"text/*", map[string]string{"id": "7"}, "text/xml", `<xml><id>7</id></xml>`},
+		{"application/json;q=0.5, application/msgpack", device{Serial: "a1"}, "application/msgpack",
+			"\x81\xa6serial\xa2a1"},
+		// q=0 excludes what its range matches, */* included
+		{"application/json;q=0, */*", device{Serial: "a1"}, "application/xml",
+			`<device><serial>a1</serial><online>false</online></device>`},
+		{"*/*;q=0.5, application/json;q=0.2, application/xml;q=0.3", device{Serial: "a1"}, "text/xml",
+			`<device><serial>a1</serial><online>false</online></device>`},
+	}
+	for _, test := range tests {
+		w := renderRequest(newTestServer(), test.accept, test.v)
+		if w.Header().Get("Content-Type") != test.ctype || w.Body.String() != test.body {
+			t.Errorf("%q: got %q %q, want %q %q", test.accept,
+				w.Header().Get("Content-Type"), w.Body.String(), test.ctype, test.body)
+		}
+	}
+}
+
+func TestRenderNotAcceptable(t *testing.T) {
+	w := renderRequest(newTestServer(), "image/png, application/json;q=0", device{})
+	if w.Code != 406 {
+		t.Errorf("got %d, want 406", w.Code)
+	}
+}
+
+func TestRenderExcluded(t *testing.T) {
+	w := renderRequest(newTestServer(), "application/*;q=0, text/*;q=0, */*", device{})
+	if w.Code != 406 {
+		t.Errorf("got %d, want 406", w.Code)
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	s := newTestServer()
+	s.RegisterCodec("text/plain", CodecFunc(func(w io.Writer, v interface{}) error {
+		_, err := fmt.Fprint(w, v)
+		return err
+	}))
+
+	w := renderRequest(s, "text/plain", 42)
+	if w.Header().Get("Content-Type") != "text/plain" || w.Body.String() != "42" {
+		t.Errorf("got %q %q", w.Header().Get("Content-Type"), w.Body.String())
+	}
+}
+
+func TestMsgpack(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{5, []byte{0x05}},
+		{-5, []byte{0xfb}},
+		{200, []byte{0xcc, 0xc8}},
+		{-200, []byte{0xd1, 0xff, 0x38}},
+		{70000, []byte{0xce, 0x00, 0x01, 0x11, 0x70}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{[]byte{1, 2}, []byte{0xc4, 0x02, 0x01, 0x02}},
+		{[]int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{map[string]int{"b": 2, "a": 1}, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := encodeMsgpack(&buf, test.v); err != nil {
+			t.Errorf("%v: %v", test.v, err)
+		} else if !bytes.Equal(buf.Bytes(), test.want) {
+			t.Errorf("%v: got % x, want % x", test.v, buf.Bytes(), test.want)
+		}
+	}
+}
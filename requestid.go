@@ -0,0 +1,83 @@
+package next
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader carries the ID of a request between services.
+const RequestIDHeader = "X-Request-Id"
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether an ID sent by a client is fit for logs
+// and headers: up to 128 printable ASCII characters.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestID returns the ID of the request: the X-Request-Id header of the
+// request when it has a valid one, else a new ID. It is sent back in the
+// X-Request-Id header of the response.
+func (ctx *Context) RequestID() string {
+	return ctx.reqID
+}
+
+// Log returns a logger stamping the ID of the request on every line, over
+// the Log of the server.
+func (ctx *Context) Log() *RequestLogger {
+	return ctx.Server.Log.Request(ctx.reqID)
+}
+
+// HTTPClient returns a client for calls to other services made on behalf
+// of the request, which carry its ID in the X-Request-Id header.
+func (ctx *Context) HTTPClient() *http.Client {
+	return &http.Client{Transport: &requestIDTransport{id: ctx.reqID}}
+}
+
+type requestIDTransport struct {
+	id   string
+	base http.RoundTripper
+}
+
+func (t *requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Header.Get(RequestIDHeader) != "" {
+		return base.RoundTrip(req)
+	}
+	// a RoundTripper must not change the request it is given
+	r := req.Clone(req.Context())
+	r.Header.Set(RequestIDHeader, t.id)
+	return base.RoundTrip(r)
+}
+
+// RequestID returns the ID of the message, made for it, unlike its seq
+// which is only unique to the connection. It is sent back in the
+// request_id of the answer, next to seq.
+func (ctx *TcpContext) RequestID() string {
+	return ctx.reqID
+}
+
+// Log returns a logger stamping the ID of the message on every line, over
+// the Log of the server.
+func (ctx *TcpContext) Log() *RequestLogger {
+	return ctx.Tcp.Log.Request(ctx.reqID)
+}
@@ -0,0 +1,82 @@
+package next
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	s := newTestServer()
+	var out strings.Builder
+	s.Log = New(&out, "", 0)
+	s.Get("/", func(ctx *Context) string {
+		ctx.Log().Info("handling")
+		return ctx.RequestID()
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-Id", "abc-123")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if got := w.Header().Get("X-Request-Id"); got != "abc-123" || w.Body.String() != "abc-123" {
+		t.Errorf("request ID %q, body %q", got, w.Body.String())
+	}
+	if !strings.Contains(out.String(), "[abc-123]") {
+		t.Errorf("log %q lacks the request ID", out.String())
+	}
+
+	// invalid IDs are replaced
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-Id", "bad id\n")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if got := w.Header().Get("X-Request-Id"); len(got) != 32 || got != w.Body.String() {
+		t.Errorf("generated request ID %q, body %q", got, w.Body.String())
+	}
+}
+
+func TestTcpRequestID(t *testing.T) {
+	tcp := NewTcp()
+	tcp.Logger.SetOutput(ioutil.Discard)
+	tcp.Via("ping", func(ctx *TcpContext) {
+		ctx.WriteJSON("200", ctx.RequestID())
+	})
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		tcp.dispatch("fd", func(out []byte) error {
+			json := NewJson()
+			json.Load(out)
+			if json.Get("seq").MustString() != "1" || json.Get("request_id").MustString() != json.Get("msg").MustString() {
+				t.Errorf("answer %s", out)
+			}
+			ids = append(ids, json.Get("request_id").MustString())
+			return nil
+		}, []byte(`{"method": "ping", "seq": "1"}`))
+	}
+	// the seq repeats, the request ID does not
+	if len(ids) != 2 || len(ids[0]) != 32 || ids[0] == ids[1] {
+		t.Errorf("request IDs %q", ids)
+	}
+}
+
+func TestRequestIDClient(t *testing.T) {
+	var got []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("X-Request-Id"))
+	}))
+	defer upstream.Close()
+
+	ctx := &Context{reqID: "abc-123"}
+	client := ctx.HTTPClient()
+	client.Get(upstream.URL)
+	req, _ := http.NewRequest("GET", upstream.URL, nil)
+	req.Header.Set("X-Request-Id", "other")
+	client.Do(req)
+	if len(got) != 2 || got[0] != "abc-123" || got[1] != "other" {
+		t.Errorf("forwarded request IDs %q", got)
+	}
+}
@@ -0,0 +1,181 @@
+package next
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// responseWriter wraps the http.ResponseWriter of a request to record the
+// status, size and state of the response, for the middleware running after
+// the handler and for the access log. It lets Flush, Hijack and Push
+// through to the writer of the http server.
+type responseWriter struct {
+	http.ResponseWriter
+	mu       sync.Mutex
+	status   int
+	size     int64
+	written  bool
+	hijacked bool
+	// a writer over this one holds back a response begun, see Compress
+	held bool
+	// a handler with a time limit writes its own header, see runChain
+	header   http.Header
+	deadline time.Time
+	timedOut bool
+}
+
+func (w *responseWriter) Header() http.Header {
+	if w.header != nil {
+		return w.header
+	}
+	return w.ResponseWriter.Header()
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writeHeader(status)
+}
+
+func (w *responseWriter) writeHeader(status int) {
+	if w.written || w.late() {
+		return
+	}
+	if w.header != nil {
+		h := w.ResponseWriter.Header()
+		for k := range h {
+			if _, ok := w.header[k]; !ok {
+				delete(h, k)
+			}
+		}
+		for k, v := range w.header {
+			h[k] = v
+		}
+	}
+	if status >= 100 && status < 200 && status != 101 {
+		// informational, followed by the actual header
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status, w.written = status, true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.late() {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.writeHeader(200)
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps io.Copy to the connection of the http server, which may
+// send files with sendfile.
+func (w *responseWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.late() {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.writeHeader(200)
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(w.ResponseWriter, r)
+	}
+	w.size += n
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.late() {
+		w.writeHeader(200)
+		f.Flush()
+	}
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("next: the ResponseWriter does not support hijacking")
+	}
+	if w.late() {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	conn, rw, err := hj.Hijack()
+	if err == nil {
+		w.written, w.hijacked = true, true
+	}
+	return conn, rw, err
+}
+
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap returns the writer of the http server, for http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Status returns the status code of the response, 0 until it is written.
+// Middleware may read it after calling ctx.Next.
+func (ctx *Context) Status() int {
+	if ctx.writer == nil {
+		return 0
+	}
+	ctx.writer.mu.Lock()
+	defer ctx.writer.mu.Unlock()
+	return ctx.writer.status
+}
+
+// Size returns the number of bytes of the response body written so far.
+func (ctx *Context) Size() int64 {
+	if ctx.writer == nil {
+		return 0
+	}
+	ctx.writer.mu.Lock()
+	defer ctx.writer.mu.Unlock()
+	return ctx.writer.size
+}
+
+// Written reports whether the header of the response is written, after
+// which the status and headers can no longer change. A response held back
+// by the Compress middleware counts as written once it is begun.
+func (ctx *Context) Written() bool {
+	if ctx.writer == nil {
+		return false
+	}
+	ctx.writer.mu.Lock()
+	defer ctx.writer.mu.Unlock()
+	return ctx.writer.written || ctx.writer.held
+}
+
+// hold records whether a writer over w holds back a response begun.
+func (w *responseWriter) hold(held bool) {
+	w.mu.Lock()
+	w.held = held
+	w.mu.Unlock()
+}
@@ -0,0 +1,93 @@
+package next
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponseStatus(t *testing.T) {
+	s := newTestServer()
+	var status int
+	var size int64
+	s.Use(func(ctx *Context) {
+		ctx.Next()
+		status, size = ctx.Status(), ctx.Size()
+	})
+	s.Get("/created", func(ctx *Context) {
+		ctx.ResponseWriter.WriteHeader(201)
+		ctx.WriteString("made")
+	})
+	s.Handler("/std", "GET", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "teapot", 418)
+	}))
+
+	serve(s, "GET", "/created")
+	if status != 201 || size != 4 {
+		t.Errorf("status %d, size %d; want 201, 4", status, size)
+	}
+	serve(s, "GET", "/std")
+	if status != 418 || size != 7 {
+		t.Errorf("status %d, size %d; want 418, 7", status, size)
+	}
+}
+
+func TestResponsePanicAfterWrite(t *testing.T) {
+	s := newTestServer()
+	s.Get("/", func(ctx *Context) {
+		ctx.WriteString("partial")
+		panic("oops")
+	})
+	w := serve(s, "GET", "/")
+	if w.Code != 200 || w.Body.String() != "partial" {
+		t.Errorf("got %d %q, want 200 partial", w.Code, w.Body.String())
+	}
+}
+
+func TestResponseInterfaces(t *testing.T) {
+	s := newTestServer()
+	s.Get("/flush", func(ctx *Context) {
+		ctx.WriteString("a")
+		ctx.ResponseWriter.(http.Flusher).Flush()
+	})
+	s.Get("/hijack", func(ctx *Context) {
+		conn, rw, err := ctx.ResponseWriter.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nhi")
+		rw.Flush()
+		if !ctx.Written() {
+			t.Error("hijacked response not written")
+		}
+	})
+
+	w := serve(s, "GET", "/flush")
+	if !w.Flushed {
+		t.Error("response not flushed")
+	}
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/hijack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := bufio.NewReader(resp.Body).ReadString('\n')
+	if body != "hi" {
+		t.Errorf("hijacked body %q", body)
+	}
+
+	var rw http.ResponseWriter = &responseWriter{ResponseWriter: httptest.NewRecorder()}
+	if err := rw.(http.Pusher).Push("/a.css", nil); err != http.ErrNotSupported {
+		t.Errorf("Push = %v, want ErrNotSupported", err)
+	}
+	if _, _, err := rw.(http.Hijacker).Hijack(); err == nil || !strings.Contains(err.Error(), "hijack") {
+		t.Errorf("Hijack = %v", err)
+	}
+}
@@ -4,46 +4,157 @@ import (
 	"net/http"
 	"reflect"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
 )
 
+// Routes holds the routes of a server. Routes made of static segments,
+// named parameters (/users/:id) and a trailing catch-all (/files/*path)
+// are kept in a radix tree, so a lookup costs the length of the path and
+// not the number of routes. Any other route is taken as a regular
+// expression, matched against the whole path. Between a tree route and a
+// regular expression route matching a path, the first registered wins.
+//
+// A '.' in a tree route is matched literally: /favicon.ico no longer
+// matches /faviconXico as it did when every route was a regular
+// expression. Write the route as a regular expression, with a group for
+// instance, to keep the old meaning.
 type Routes struct {
-	data []Route
+	tree  *node
+	regex []*Route
+	// count numbers the routes in the order they are added
+	count int
 }
+
 type Route struct {
 	r           string
 	cr          *regexp.Regexp
+	names       []string
 	method      string
 	handler     reflect.Value
 	httpHandler http.Handler
+	middleware  []Middleware
+	seq         int
+	// settings shared with the copies of the route made by groups
+	conf *routeConf
+}
+
+type routeConf struct {
+	maxBody    int64
+	stream     bool
+	csrfExempt bool
+	cors       *corsPolicy
+	logSample  float64
+	timeout    time.Duration
+}
+
+// MaxBody sets the size limit of request bodies for route r, in bytes,
+// over the http.max_body setting of the server. A negative n lifts the
+// limit. Larger bodies are answered with 413 Request Entity Too Large.
+func (r *Route) MaxBody(n int64) *Route {
+	r.conf.maxBody = n
+	return r
+}
+
+// Stream leaves the request body of route r unread: ctx.Params only holds
+// the values of the query string, and the handler reads ctx.Request.Body,
+// or ctx.Body, itself. The size limit still applies to what it reads.
+func (r *Route) Stream() *Route {
+	r.conf.stream = true
+	return r
+}
+
+// CSRFExempt lets the requests of route r through the CSRF middleware,
+// for a webhook called by another server for instance.
+func (r *Route) CSRFExempt() *Route {
+	r.conf.csrfExempt = true
+	return r
+}
+
+// PathParam is a value captured from the request path, either by a named
+// segment of a tree route or by a group of a regular expression route.
+// Unnamed regular expression groups have an empty Name.
+type PathParam struct {
+	Name  string
+	Value string
 }
 
 func NewRoutes() *Routes {
-	return &Routes{}
+	return &Routes{tree: &node{}}
 }
 
-func (rs *Routes) Add(r string, method string, handler interface{}) {
-	cr, err := regexp.Compile(r)
-	if err != nil {
-		// TODO
-		// s.Logger.Printf("Error in route regex %q\n", r)
-		return
-	}
+// Add adds a route for the method to rs. The returned route may be used to
+// change its settings. It panics when rs has a tree route for the same
+// method and path already.
+func (rs *Routes) Add(r string, method string, handler interface{}, middleware ...Middleware) *Route {
+	return rs.add(newRoute(r, method, handler, middleware))
+}
+
+func newRoute(r string, method string, handler interface{}, middleware []Middleware) *Route {
+	route := &Route{r: r, method: method, middleware: middleware, conf: &routeConf{}}
 
 	switch handler.(type) {
 	case http.Handler:
-		rs.data = append(rs.data, Route{r: r, cr: cr, method: method, httpHandler: handler.(http.Handler)})
+		route.httpHandler = handler.(http.Handler)
 	case reflect.Value:
-		fv := handler.(reflect.Value)
-		rs.data = append(rs.data, Route{r: r, cr: cr, method: method, handler: fv})
+		route.handler = handler.(reflect.Value)
 	default:
-		fv := reflect.ValueOf(handler)
-		rs.data = append(rs.data, Route{r: r, cr: cr, method: method, handler: fv})
+		route.handler = reflect.ValueOf(handler)
 	}
+	if route.handler.Kind() == reflect.Func {
+		if err := checkResults(route.handler.Type()); err != nil {
+			panic(err)
+		}
+	}
+	return route
 }
 
-func (s *Routes) Match(r, method string) *Route {
-	for i := 0; i < len(s.data); i++ {
-		route := &s.data[i]
+func (rs *Routes) add(route *Route) *Route {
+	r, method := route.r, route.method
+	route.cr, route.names = nil, nil
+	rs.count++
+	route.seq = rs.count
+	if isTreeRoute(r) {
+		n, names := rs.tree.insert(r)
+		route.names = names
+		if n.routes == nil {
+			n.routes = make(map[string]*Route)
+		}
+		// as http.ServeMux does: a route added twice would be a dead one,
+		// whose settings change nothing
+		if _, ok := n.routes[method]; ok {
+			panic("next: route " + method + " " + r + " added twice")
+		}
+		n.routes[method] = route
+		return route
+	}
+
+	cr, err := regexp.Compile(r)
+	if err != nil {
+		// TODO
+		// s.Logger.Printf("Error in route regex %q\n", r)
+		return route
+	}
+	route.cr = cr
+	rs.regex = append(rs.regex, route)
+	return route
+}
+
+func (s *Routes) Match(r, method string) (*Route, []PathParam) {
+	var found *Route
+	_, values := s.tree.lookup(r, nil, func(n *node) bool {
+		found = n.routes[method]
+		if found == nil && method == "HEAD" {
+			found = n.routes["GET"]
+		}
+		return found != nil
+	})
+	for _, route := range s.regex {
+		if found != nil && route.seq > found.seq {
+			// the tree route came first
+			break
+		}
 		cr := route.cr
 		//if the methods don't match, skip this handler (except HEAD can be used in place of GET)
 		if method != route.method && !(method == "HEAD" && route.method == "GET") {
@@ -58,8 +169,212 @@ func (s *Routes) Match(r, method string) *Route {
 			continue
 		}
 
-		return route
+		names := cr.SubexpNames()
+		params := make([]PathParam, len(match)-1)
+		for i, v := range match[1:] {
+			params[i] = PathParam{Name: names[i+1], Value: v}
+		}
+		return route, params
 	}
 
+	if found != nil {
+		params := make([]PathParam, len(values))
+		for i, v := range values {
+			params[i] = PathParam{Name: found.names[i], Value: v}
+		}
+		return found, params
+	}
+	return nil, nil
+}
+
+// Allowed returns the methods of the routes matching path r, sorted. HEAD
+// is allowed along with GET, and OPTIONS as soon as any method is. It is
+// empty when no route matches the path at all.
+func (s *Routes) Allowed(r string) []string {
+	methods := make(map[string]bool)
+	s.tree.lookup(r, nil, func(n *node) bool {
+		for method := range n.routes {
+			methods[method] = true
+		}
+		// keep walking, other nodes may match the path too
+		return false
+	})
+
+	for _, route := range s.regex {
+		if methods[route.method] {
+			continue
+		}
+		match := route.cr.FindStringIndex(r)
+		if match != nil && match[1]-match[0] == len(r) {
+			methods[route.method] = true
+		}
+	}
+
+	if len(methods) == 0 {
+		return nil
+	}
+	if methods["GET"] {
+		methods["HEAD"] = true
+	}
+	methods["OPTIONS"] = true
+
+	allowed := make([]string, 0, len(methods))
+	for method := range methods {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	return allowed
+}
+
+// isTreeRoute reports whether route r can be stored in the tree, that is
+// it has no regular expression syntax besides a ':' or '*' opening a
+// segment. A catch-all has to be the last segment.
+func isTreeRoute(r string) bool {
+	if strings.ContainsAny(r, `()[]{}?+^$|\`) {
+		return false
+	}
+	for i := 0; i < len(r); i++ {
+		if r[i] != ':' && r[i] != '*' {
+			continue
+		}
+		if i > 0 && r[i-1] != '/' {
+			// a ':' inside a segment is taken literally
+			if r[i] == ':' {
+				continue
+			}
+			return false
+		}
+		end := strings.IndexByte(r[i:], '/')
+		if end == 1 || i == len(r)-1 {
+			// unnamed parameter
+			return false
+		}
+		if r[i] == '*' && end >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// node is a node of the radix tree of Routes. Static children are keyed by
+// the first byte of their path; a parameter or catch-all child matches a
+// segment or the rest of the path, whatever its content.
+type node struct {
+	path     string
+	children []*node
+	param    *node
+	catchAll *node
+	routes   map[string]*Route
+}
+
+// insert adds the pattern to the tree rooted at n and returns the node the
+// pattern ends at, with the names of its parameters.
+func (n *node) insert(pattern string) (*node, []string) {
+	var names []string
+	for pattern != "" {
+		switch pattern[0] {
+		case ':':
+			end := strings.IndexByte(pattern, '/')
+			if end < 0 {
+				end = len(pattern)
+			}
+			names = append(names, pattern[1:end])
+			if n.param == nil {
+				n.param = &node{}
+			}
+			n = n.param
+			pattern = pattern[end:]
+		case '*':
+			names = append(names, pattern[1:])
+			if n.catchAll == nil {
+				n.catchAll = &node{}
+			}
+			n = n.catchAll
+			pattern = ""
+		default:
+			end := 0
+			for end < len(pattern) {
+				c := pattern[end]
+				if (c == ':' || c == '*') && end > 0 && pattern[end-1] == '/' {
+					break
+				}
+				end++
+			}
+			n = n.insertStatic(pattern[:end])
+			pattern = pattern[end:]
+		}
+	}
+	return n, names
+}
+
+// insertStatic adds the static path s below n, splitting the edges that
+// share a prefix with s.
+func (n *node) insertStatic(s string) *node {
+	for {
+		child := n.child(s[0])
+		if child == nil {
+			child = &node{path: s}
+			n.children = append(n.children, child)
+			return child
+		}
+
+		l := 0
+		for l < len(s) && l < len(child.path) && s[l] == child.path[l] {
+			l++
+		}
+		if l < len(child.path) {
+			rest := *child
+			rest.path = child.path[l:]
+			*child = node{path: child.path[:l], children: []*node{&rest}}
+		}
+		if l == len(s) {
+			return child
+		}
+		n = child
+		s = s[l:]
+	}
+}
+
+func (n *node) child(c byte) *node {
+	for _, child := range n.children {
+		if child.path[0] == c {
+			return child
+		}
+	}
 	return nil
 }
+
+// lookup walks the tree for path, the part of the request path left once
+// n is reached, trying static children before parameters and parameters
+// before a catch-all. It returns the first node with routes for which
+// accept is true, along with the parameter values met on the way.
+func (n *node) lookup(path string, values []string, accept func(*node) bool) (*node, []string) {
+	if path == "" && n.routes != nil && accept(n) {
+		return n, values
+	}
+
+	if path != "" {
+		if child := n.child(path[0]); child != nil && strings.HasPrefix(path, child.path) {
+			if m, v := child.lookup(path[len(child.path):], values, accept); m != nil {
+				return m, v
+			}
+		}
+
+		if n.param != nil {
+			end := strings.IndexByte(path, '/')
+			if end < 0 {
+				end = len(path)
+			}
+			if end > 0 {
+				if m, v := n.param.lookup(path[end:], append(values, path[:end]), accept); m != nil {
+					return m, v
+				}
+			}
+		}
+	}
+
+	if n.catchAll != nil && n.catchAll.routes != nil && accept(n.catchAll) {
+		return n.catchAll, append(values, path)
+	}
+	return nil, nil
+}
@@ -0,0 +1,108 @@
+package next
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRoutesMatch(t *testing.T) {
+	rs := NewRoutes()
+	for _, r := range []string{
+		"/",
+		"/users",
+		"/users/new",
+		"/users/:id",
+		"/users/:id/posts/:post",
+		"/files/*path",
+		"/favicon.ico",
+		`/legacy/(\d+)`,
+		`/named/(?P<name>\w+)`,
+	} {
+		rs.Add(r, "GET", r)
+	}
+
+	tests := []struct {
+		path   string
+		route  string
+		params string
+	}{
+		{"/", "/", "[]"},
+		{"/users", "/users", "[]"},
+		{"/users/new", "/users/new", "[]"},
+		{"/users/42", "/users/:id", "[{id 42}]"},
+		{"/users/42/posts/7", "/users/:id/posts/:post", "[{id 42} {post 7}]"},
+		{"/files/a/b.txt", "/files/*path", "[{path a/b.txt}]"},
+		{"/favicon.ico", "/favicon.ico", "[]"},
+		{"/legacy/12", `/legacy/(\d+)`, "[{ 12}]"},
+		{"/named/fred", `/named/(?P<name>\w+)`, "[{name fred}]"},
+		{"/legacy/x", "", "[]"},
+		{"/users/42/comments", "", "[]"},
+		{"/faviconXico", "", "[]"},
+	}
+	for _, test := range tests {
+		route, params := rs.Match(test.path, "GET")
+		r := ""
+		if route != nil {
+			r = route.r
+		}
+		if r != test.route || fmt.Sprint(params) != test.params {
+			t.Errorf("%s: got %q %v, want %q %s", test.path, r, params, test.route, test.params)
+		}
+	}
+}
+
+func TestRoutesOrder(t *testing.T) {
+	rs := NewRoutes()
+	rs.Add(`/users/(\d+)`, "GET", "regex")
+	rs.Add("/users/:id", "GET", "tree")
+	rs.Add("/posts/:id", "GET", "tree")
+	rs.Add(`/posts/(\d+)`, "GET", "regex")
+
+	// the first registered wins, a tree route or not
+	if route, _ := rs.Match("/users/42", "GET"); route == nil || route.r != `/users/(\d+)` {
+		t.Errorf("/users/42: got %v, want the regex route", route)
+	}
+	if route, _ := rs.Match("/users/fred", "GET"); route == nil || route.r != "/users/:id" {
+		t.Errorf("/users/fred: got %v, want the tree route", route)
+	}
+	if route, _ := rs.Match("/posts/42", "GET"); route == nil || route.r != "/posts/:id" {
+		t.Errorf("/posts/42: got %v, want the tree route", route)
+	}
+}
+
+func TestRoutesTwice(t *testing.T) {
+	rs := NewRoutes()
+	rs.Add("/users/:id", "GET", "first")
+	rs.Add("/users/:id", "POST", "other method")
+	defer func() {
+		if recover() == nil {
+			t.Error("route added twice without a panic")
+		}
+	}()
+	rs.Add("/users/:name", "GET", "second")
+}
+
+func TestRoutesMethod(t *testing.T) {
+	rs := NewRoutes()
+	rs.Add("/users/new", "GET", "new")
+	rs.Add("/users/:id", "POST", "update")
+
+	if route, _ := rs.Match("/users/new", "HEAD"); route == nil || route.method != "GET" {
+		t.Error("HEAD does not fall back to GET")
+	}
+	// the static node has no POST route, the parameter one has
+	if route, params := rs.Match("/users/new", "POST"); route == nil || params[0].Value != "new" {
+		t.Error("POST /users/new does not reach /users/:id")
+	}
+}
+
+func TestPathParamHandler(t *testing.T) {
+	s := newTestServer()
+	s.Get("/users/:id", func(ctx *Context, id string) string {
+		return id + "=" + ctx.PathParam("id")
+	})
+
+	if w := serve(s, "GET", "/users/7"); w.Body.String() != "7=7" {
+		t.Errorf("body = %q, want 7=7", w.Body.String())
+	}
+}
@@ -0,0 +1,280 @@
+package next
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A Session holds the data of a client across requests. Its ID travels in
+// a signed cookie, named by the session.name setting ("next_session" by
+// default); its values stay in the SessionStore of the server, encoded as
+// JSON, for session.ttl seconds (a day by default) after the last request.
+// Cookies are signed with cookie.secret, which must be set.
+//
+// Values come back from the store as JSON does: numbers as float64, objects
+// as map[string]interface{}. The typed getters convert them.
+type Session struct {
+	id     string
+	values map[string]interface{}
+	// id of the session before Regenerate, to delete from the store
+	oldID     string
+	destroyed bool
+	// whether the client has the cookie of id; the session is not saved
+	// otherwise, nobody could come back to it
+	sent bool
+}
+
+// ID returns the ID of session s.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Get returns the value of key, or nil.
+func (s *Session) Get(key string) interface{} {
+	return s.values[key]
+}
+
+// String returns the value of key if it is a string.
+func (s *Session) String(key string) string {
+	v, _ := s.values[key].(string)
+	return v
+}
+
+// Int returns the value of key if it is a number, or a string holding one.
+func (s *Session) Int(key string) int {
+	switch v := s.values[key].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	return 0
+}
+
+// Bool returns the value of key if it is a boolean.
+func (s *Session) Bool(key string) bool {
+	v, _ := s.values[key].(bool)
+	return v
+}
+
+// Set sets the value of key. It must encode to JSON.
+func (s *Session) Set(key string, v interface{}) {
+	s.values[key] = v
+}
+
+// Delete removes key from the session.
+func (s *Session) Delete(key string) {
+	delete(s.values, key)
+}
+
+const flashPrefix = "_flash."
+
+// SetFlash sets a value of the session that is read only once, by Flash,
+// usually on the next page: "profile saved".
+func (s *Session) SetFlash(key string, v interface{}) {
+	s.values[flashPrefix+key] = v
+}
+
+// Flash returns the flash value of key and removes it.
+func (s *Session) Flash(key string) interface{} {
+	v := s.values[flashPrefix+key]
+	delete(s.values, flashPrefix+key)
+	return v
+}
+
+// Session returns the session of the request, starting a new one when the
+// client has none or it expired. It panics when cookie.secret is not set.
+// The cookie of the session goes with the headers, so Session is to be
+// called before the body is written; a session started later is logged and
+// not saved.
+func (ctx *Context) Session() *Session {
+	if ctx.session == nil {
+		if len(ctx.Server.Config.Strings("cookie.secret")) == 0 {
+			panic("next: sessions need cookie.secret to be set")
+		}
+		ctx.loadSession()
+		// sent again on every request, as the expiry slides
+		ctx.setSessionCookie()
+	}
+	return ctx.session
+}
+
+func (ctx *Context) loadSession() {
+	store := ctx.Server.sessionStore()
+	if id, ok := ctx.GetSecureCookie(ctx.Server.sessionName()); ok && validSessionID(id) {
+		data, err := store.Load(id)
+		if err != nil {
+			ctx.Server.Logger.Println("Session load failed", err)
+		}
+		values := map[string]interface{}{}
+		if data != nil && json.Unmarshal(data, &values) == nil {
+			ctx.session = &Session{id: id, values: values, sent: true}
+		}
+	}
+	if ctx.session == nil {
+		ctx.session = &Session{id: newSessionID(), values: map[string]interface{}{}}
+	}
+}
+
+// RegenerateSession gives the session a new ID, keeping its values. It is
+// to be called when the privileges of the client change, on login above
+// all, so that an ID set by someone else beforehand is of no use.
+func (ctx *Context) RegenerateSession() *Session {
+	s := ctx.Session()
+	if s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = newSessionID()
+	s.sent = false
+	ctx.setSessionCookie()
+	return s
+}
+
+// DestroySession removes the session from the store and the client.
+func (ctx *Context) DestroySession() {
+	if ctx.session == nil {
+		ctx.loadSession()
+	}
+	s := ctx.session
+	s.destroyed = true
+	s.values = map[string]interface{}{}
+	ctx.SetCookie(&http.Cookie{Name: ctx.Server.sessionName(), Path: "/", MaxAge: -1})
+}
+
+func (ctx *Context) setSessionCookie() {
+	if ctx.Written() {
+		ctx.Server.Logger.Println("Session cookie not sent, the response is already written:", ctx.Request.URL.Path)
+		return
+	}
+	ttl := ctx.Server.sessionTTL()
+	value := ctx.signCookie(ctx.Server.sessionName(), ctx.session.id)
+	if value == "" {
+		return
+	}
+	cookie := NewCookie(ctx.Server.sessionName(), value, int64(ttl/time.Second))
+	cookie.Path = "/"
+	cookie.HttpOnly = true
+	ctx.SetCookie(cookie)
+	ctx.session.sent = true
+}
+
+// saveSession writes the session of the request, if any, to the store once
+// the request is served.
+func (ctx *Context) saveSession() {
+	s := ctx.session
+	if s == nil || !s.sent && !s.destroyed {
+		return
+	}
+	store := ctx.Server.sessionStore()
+	if s.oldID != "" {
+		if err := store.Delete(s.oldID); err != nil {
+			ctx.Server.Logger.Println("Session delete failed", err)
+		}
+	}
+	if s.destroyed {
+		if err := store.Delete(s.id); err != nil {
+			ctx.Server.Logger.Println("Session delete failed", err)
+		}
+		return
+	}
+
+	data, err := json.Marshal(s.values)
+	if err == nil {
+		err = store.Save(s.id, data, ctx.Server.sessionTTL())
+	}
+	if err != nil {
+		ctx.Server.Logger.Println("Session save failed", err)
+	}
+}
+
+func newSessionID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func validSessionID(id string) bool {
+	if len(id) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// sessions is the session store of a server and its garbage collector.
+type sessions struct {
+	mu     sync.Mutex
+	store  SessionStore
+	stopGC chan struct{}
+}
+
+// SetSessionStore sets the store of the sessions of server s, a MemoryStore
+// by default, and removes the expired sessions from it every session.gc
+// seconds (ten minutes by default).
+func (s *Server) SetSessionStore(store SessionStore) {
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+	s.startSessions(store)
+}
+
+func (s *Server) startSessions(store SessionStore) {
+	if s.sessions.stopGC != nil {
+		close(s.sessions.stopGC)
+	}
+	stop := make(chan struct{})
+	s.sessions.store, s.sessions.stopGC = store, stop
+
+	interval := time.Duration(s.Config.Int("session.gc")) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := store.GC(); err != nil {
+					s.Logger.Println("Session gc failed", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *Server) sessionStore() SessionStore {
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+	if s.sessions.store == nil {
+		s.startSessions(NewMemoryStore())
+	}
+	return s.sessions.store
+}
+
+func (s *Server) sessionName() string {
+	if name := s.Config.String("session.name"); name != "" {
+		return name
+	}
+	return "next_session"
+}
+
+func (s *Server) sessionTTL() time.Duration {
+	if ttl := s.Config.Int("session.ttl"); ttl > 0 {
+		return time.Duration(ttl) * time.Second
+	}
+	return 24 * time.Hour
+}
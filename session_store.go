@@ -0,0 +1,255 @@
+package next
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// A SessionStore keeps the encoded values of sessions by ID.
+type SessionStore interface {
+	// Load returns the values of a session, or nil if there is no such
+	// session or it expired.
+	Load(id string) ([]byte, error)
+	// Save stores the values of a session for ttl.
+	Save(id string, data []byte, ttl time.Duration) error
+	Delete(id string) error
+	// GC removes the expired sessions.
+	GC() error
+}
+
+// MemoryStore keeps sessions in memory. They are lost when the process
+// exits, and not shared between processes.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+}
+
+type memorySession struct {
+	data    []byte
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: map[string]memorySession{}}
+}
+
+func (m *MemoryStore) Load(id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || time.Now().After(s.expires) {
+		return nil, nil
+	}
+	return s.data, nil
+}
+
+func (m *MemoryStore) Save(id string, data []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[id] = memorySession{data, time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MemoryStore) GC() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for id, s := range m.sessions {
+		if now.After(s.expires) {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+// FileStore keeps each session in a file of a directory, the expiry time
+// on the first line.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store of sessions in dir, which is created if
+// needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) path(id string) string {
+	return filepath.Join(f.dir, "sess_"+id)
+}
+
+func (f *FileStore) Load(id string) ([]byte, error) {
+	content, err := ioutil.ReadFile(f.path(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	expires, data, ok := parseSessionFile(content)
+	if !ok || time.Now().After(expires) {
+		return nil, nil
+	}
+	return data, nil
+}
+
+func parseSessionFile(content []byte) (time.Time, []byte, bool) {
+	i := bytes.IndexByte(content, '\n')
+	if i < 0 {
+		return time.Time{}, nil, false
+	}
+	unix, err := strconv.ParseInt(string(content[:i]), 10, 64)
+	if err != nil {
+		return time.Time{}, nil, false
+	}
+	return time.Unix(unix, 0), content[i+1:], true
+}
+
+func (f *FileStore) Save(id string, data []byte, ttl time.Duration) error {
+	// written aside and renamed, so that Load never sees half a file
+	tmp, err := ioutil.TempFile(f.dir, "tmp_")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(tmp, "%d\n", time.Now().Add(ttl).Unix())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(id))
+}
+
+func (f *FileStore) Delete(id string) error {
+	err := os.Remove(f.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (f *FileStore) GC() error {
+	files, err := filepath.Glob(filepath.Join(f.dir, "sess_*"))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, name := range files {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		if expires, _, ok := parseSessionFile(content); !ok || now.After(expires) {
+			os.Remove(name)
+		}
+	}
+	return nil
+}
+
+// RedisStore keeps sessions in Redis, which expires them itself.
+type RedisStore struct {
+	redis  *Redis
+	prefix string
+}
+
+// NewRedisStore returns a store of sessions in r, under keys starting
+// with prefix.
+func NewRedisStore(r *Redis, prefix string) *RedisStore {
+	return &RedisStore{redis: r, prefix: prefix}
+}
+
+func (r *RedisStore) Load(id string) ([]byte, error) {
+	data, err := redis.Bytes(r.redis.Do("GET", r.prefix+id))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (r *RedisStore) Save(id string, data []byte, ttl time.Duration) error {
+	_, err := r.redis.Do("SET", r.prefix+id, data, "PX", int64(ttl/time.Millisecond))
+	return err
+}
+
+func (r *RedisStore) Delete(id string) error {
+	_, err := r.redis.Do("DEL", r.prefix+id)
+	return err
+}
+
+func (r *RedisStore) GC() error {
+	return nil
+}
+
+// MysqlStore keeps sessions in a MySQL table made as follows:
+//
+//	CREATE TABLE `sessions` (
+//		`id` CHAR(64) NOT NULL PRIMARY KEY,
+//		`data` BLOB NOT NULL,
+//		`expires` BIGINT NOT NULL,
+//		KEY (`expires`)
+//	);
+type MysqlStore struct {
+	mysql *Mysql
+	table string
+}
+
+// NewMysqlStore returns a store of sessions in the table of m.
+func NewMysqlStore(m *Mysql, table string) *MysqlStore {
+	return &MysqlStore{mysql: m, table: strings.Replace(table, "`", "", -1)}
+}
+
+func (m *MysqlStore) Load(id string) ([]byte, error) {
+	row, err := m.mysql.Row("SELECT `data`, `expires` FROM `"+m.table+"` WHERE `id` = :id",
+		&map[string]interface{}{"id": id})
+	if err != nil {
+		return nil, err
+	}
+	r := row.(map[string]interface{})
+	data, ok := r["data"].(string)
+	if !ok {
+		return nil, nil
+	}
+	expires, _ := strconv.ParseInt(r["expires"].(string), 10, 64)
+	if time.Now().Unix() > expires {
+		return nil, nil
+	}
+	return []byte(data), nil
+}
+
+func (m *MysqlStore) Save(id string, data []byte, ttl time.Duration) error {
+	_, err := m.mysql.Db.Exec("REPLACE INTO `"+m.table+"` (`id`, `data`, `expires`) VALUES (?, ?, ?)",
+		id, data, time.Now().Add(ttl).Unix())
+	return err
+}
+
+func (m *MysqlStore) Delete(id string) error {
+	_, err := m.mysql.Exec(m.table, "del", &map[string]interface{}{"id": id})
+	return err
+}
+
+func (m *MysqlStore) GC() error {
+	_, err := m.mysql.Db.Exec("DELETE FROM `"+m.table+"` WHERE `expires` < ?", time.Now().Unix())
+	return err
+}
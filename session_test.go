@@ -0,0 +1,128 @@
+package next
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sessionServer() *Server {
+	s := newTestServer()
+	s.Config.Set("cookie.secret", "secret")
+	s.Get("/login", func(ctx *Context) {
+		ctx.RegenerateSession().Set("user", 42)
+		ctx.Session().SetFlash("notice", "welcome")
+	})
+	s.Get("/me", func(ctx *Context) interface{} {
+		sess := ctx.Session()
+		return map[string]interface{}{"user": sess.Int("user"), "notice": sess.Flash("notice")}
+	})
+	s.Get("/logout", func(ctx *Context) {
+		ctx.DestroySession()
+	})
+	return s
+}
+
+// get requests path with the cookies of jar, and keeps the ones set.
+func get(s *Server, jar map[string]*http.Cookie, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for _, c := range jar {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge < 0 {
+			delete(jar, c.Name)
+		} else {
+			jar[c.Name] = c
+		}
+	}
+	return w
+}
+
+func TestSession(t *testing.T) {
+	s := sessionServer()
+	jar := map[string]*http.Cookie{}
+
+	get(s, jar, "/me")
+	before := jar["next_session"].Value
+	get(s, jar, "/login")
+	if jar["next_session"].Value == before {
+		t.Error("session was not regenerated on login")
+	}
+
+	if w := get(s, jar, "/me"); w.Body.String() != `{"notice":"welcome","user":42}` {
+		t.Errorf("got %s", w.Body.String())
+	}
+	if w := get(s, jar, "/me"); w.Body.String() != `{"notice":null,"user":42}` {
+		t.Errorf("flash read twice: got %s", w.Body.String())
+	}
+
+	get(s, jar, "/logout")
+	if w := get(s, jar, "/me"); w.Body.String() != `{"notice":null,"user":0}` {
+		t.Errorf("after logout: got %s", w.Body.String())
+	}
+}
+
+func TestSessionStores(t *testing.T) {
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, store := range map[string]SessionStore{"memory": NewMemoryStore(), "file": fs} {
+		store.Save("a", []byte(`{"k":1}`), time.Hour)
+		store.Save("b", []byte(`{}`), -time.Second)
+
+		if data, _ := store.Load("a"); string(data) != `{"k":1}` {
+			t.Errorf("%s: loaded %q", name, data)
+		}
+		if data, _ := store.Load("b"); data != nil {
+			t.Errorf("%s: loaded expired session %q", name, data)
+		}
+		store.GC()
+		store.Delete("a")
+		if data, _ := store.Load("a"); data != nil {
+			t.Errorf("%s: loaded deleted session %q", name, data)
+		}
+	}
+}
+
+func TestSessionMisuse(t *testing.T) {
+	s := newTestServer()
+	s.Get("/", func(ctx *Context) {
+		ctx.Session()
+	})
+	if w := serve(s, "GET", "/"); w.Code != 500 {
+		t.Errorf("without cookie.secret: got %d, want 500", w.Code)
+	}
+
+	s = newTestServer()
+	s.Config.Set("cookie.secret", "secret")
+	store := NewMemoryStore()
+	s.SetSessionStore(store)
+	lines := make(lineWriter, 10)
+	s.Logger.SetOutput(lines)
+	var id string
+	s.Get("/", func(ctx *Context) {
+		ctx.WriteString("body first")
+		id = ctx.Session().ID()
+	})
+	w := serve(s, "GET", "/")
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("session cookie set after the body")
+	}
+	if data, _ := store.Load(id); data != nil {
+		t.Error("session without a cookie saved")
+	}
+	select {
+	case line := <-lines:
+		if !strings.Contains(line, "already written") {
+			t.Errorf("logged %q", line)
+		}
+	default:
+		t.Error("late session not logged")
+	}
+}
@@ -0,0 +1,143 @@
+package next
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+)
+
+// ShutdownError is returned by Shutdown when its context is done before
+// every connection was drained. Forced lists the remote addresses of the
+// connections that were closed in the middle of a request.
+type ShutdownError struct {
+	Forced []string
+	Err    error
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("next: shutdown closed %d busy connection(s): %v", len(e.Forced), e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+// connTracker holds what Tcp and Duo need to shut down gracefully: the
+// listener, the connections being served and which of them are inside a
+// handler.
+type connTracker struct {
+	mu       sync.Mutex
+	listener *net.TCPListener
+	closing  bool
+	busy     map[string]bool
+	pipes    sync.WaitGroup
+}
+
+// listen records l as the listener to close on shutdown. It is false if
+// the server is already shutting down.
+func (ct *connTracker) listen(l *net.TCPListener) bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	if ct.closing {
+		return false
+	}
+	ct.listener = l
+	return true
+}
+
+func (ct *connTracker) isClosing() bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	return ct.closing
+}
+
+// add saves conn in conns. It is false if the server is shutting down.
+func (ct *connTracker) add(conns map[string]*net.TCPConn, fd string, conn *net.TCPConn) bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	if ct.closing {
+		return false
+	}
+	conns[fd] = conn
+	ct.pipes.Add(1)
+	return true
+}
+
+func (ct *connTracker) remove(conns map[string]*net.TCPConn, fd string) {
+	ct.mu.Lock()
+	delete(conns, fd)
+	delete(ct.busy, fd)
+	ct.mu.Unlock()
+
+	ct.pipes.Done()
+}
+
+// begin marks fd as busy before a message is handled. It is false if the
+// server is shutting down, in which case the message is dropped.
+func (ct *connTracker) begin(fd string) bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	if ct.closing {
+		return false
+	}
+	if ct.busy == nil {
+		ct.busy = make(map[string]bool)
+	}
+	ct.busy[fd] = true
+	return true
+}
+
+// end marks fd as idle again. It is false if the server started to shut
+// down while the message was handled, and the connection must be closed.
+func (ct *connTracker) end(fd string) bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	delete(ct.busy, fd)
+	return !ct.closing
+}
+
+// shutdown closes the listener and the idle connections of conns, then
+// waits for the busy ones to finish their message until ctx is done.
+func (ct *connTracker) shutdown(ctx context.Context, conns map[string]*net.TCPConn) error {
+	ct.mu.Lock()
+	ct.closing = true
+	if ct.listener != nil {
+		ct.listener.Close()
+	}
+	for fd, conn := range conns {
+		if !ct.busy[fd] {
+			conn.Close()
+		}
+	}
+	ct.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		ct.pipes.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	ct.mu.Lock()
+	forced := make([]string, 0, len(conns))
+	for fd, conn := range conns {
+		forced = append(forced, fd)
+		conn.Close()
+	}
+	ct.mu.Unlock()
+
+	sort.Strings(forced)
+	return &ShutdownError{Forced: forced, Err: ctx.Err()}
+}
@@ -0,0 +1,327 @@
+package next
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// DefaultHeartbeat is the time between the heartbeats of event streams
+// when the sse.heartbeat setting, in seconds, is not set.
+const DefaultHeartbeat = 15 * time.Second
+
+// An EventStream sends Server-Sent Events to a client, see ctx.SSE.
+type EventStream struct {
+	ctx     *Context
+	flusher http.Flusher
+	done    chan struct{}
+	once    sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+// SSE starts a stream of Server-Sent Events in the response of ctx, for
+// an EventSource of a page. Comments are sent as heartbeats, so that
+// proxies keep the connection open, until the client leaves, the stream
+// is closed or the handler returns. The route of a stream is to have no time limit, see
+// Route.Timeout.
+//
+//	s.Get("/devices/events", func(ctx *next.Context) error {
+//		stream, err := ctx.SSE()
+//		if err != nil {
+//			return err
+//		}
+//		defer stream.Close()
+//		return stream.Follow(hub, "devices")
+//	}).Timeout(-1)
+func (ctx *Context) SSE() (*EventStream, error) {
+	flusher, ok := ctx.ResponseWriter.(http.Flusher)
+	if !ok {
+		return nil, errors.New("next: the ResponseWriter does not support flushing")
+	}
+
+	h := ctx.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Del("Content-Length")
+	// tell nginx not to buffer the stream
+	h.Set("X-Accel-Buffering", "no")
+	ctx.ResponseWriter.WriteHeader(200)
+	flusher.Flush()
+
+	heartbeat := DefaultHeartbeat
+	if n := ctx.Server.Config.Int("sse.heartbeat"); n > 0 {
+		heartbeat = time.Duration(n) * time.Second
+	}
+	st := &EventStream{ctx: ctx, flusher: flusher, done: make(chan struct{})}
+	ctx.events = st
+	go st.heartbeat(heartbeat)
+	return st, nil
+}
+
+func (st *EventStream) heartbeat(d time.Duration) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+	gone := st.ctx.Request.Context().Done()
+	for {
+		select {
+		case <-st.done:
+			return
+		case <-gone:
+			st.Close()
+			return
+		case <-t.C:
+			if st.write([]byte(":\n\n")) != nil {
+				st.Close()
+				return
+			}
+		}
+	}
+}
+
+// LastEventID returns the ID of the last event the client got, which it
+// sends when it reconnects to resume the stream.
+func (st *EventStream) LastEventID() string {
+	return st.ctx.Request.Header.Get("Last-Event-ID")
+}
+
+// Done returns a channel closed when the client leaves or the stream is
+// closed.
+func (st *EventStream) Done() <-chan struct{} {
+	return st.done
+}
+
+// Close ends the stream; the handler returning ends the response. No
+// write is under way once it returns.
+func (st *EventStream) Close() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.once.Do(func() {
+		close(st.done)
+	})
+}
+
+// closeEvents closes the event stream of ctx, if any, when the handler
+// returns: its heartbeats are not to outlive the response.
+func (ctx *Context) closeEvents() {
+	if ctx.events != nil {
+		ctx.events.Close()
+	}
+}
+
+// Send sends an event to the client. Event names it, "message" when
+// empty, and id is the ID the client sends back in Last-Event-ID when it
+// reconnects, if not empty. A string or []byte data is sent as is; any
+// other value is encoded to JSON.
+func (st *EventStream) Send(event, id string, data interface{}) error {
+	var b bytes.Buffer
+	if id != "" {
+		b.WriteString("id: " + oneLine(id) + "\n")
+	}
+	if event != "" {
+		b.WriteString("event: " + oneLine(event) + "\n")
+	}
+
+	var text string
+	switch v := data.(type) {
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		out, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		text = string(out)
+	}
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return st.write(b.Bytes())
+}
+
+// Retry tells the client how long to wait before it reconnects.
+func (st *EventStream) Retry(d time.Duration) error {
+	return st.write([]byte("retry: " + strconv.FormatInt(int64(d/time.Millisecond), 10) + "\n\n"))
+}
+
+func (st *EventStream) write(b []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.err != nil {
+		return st.err
+	}
+	select {
+	case <-st.done:
+		st.err = errors.New("next: event stream closed")
+		return st.err
+	default:
+	}
+	if _, err := st.ctx.ResponseWriter.Write(b); err != nil {
+		st.err = err
+		return err
+	}
+	st.flusher.Flush()
+	return nil
+}
+
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// Follow sends the events of topic of hub to the client until it leaves
+// or the stream is closed. The events the client missed since its
+// LastEventID, among the ones the hub keeps, are sent first. A client too
+// slow for the topic is let go, to resume when it reconnects.
+func (st *EventStream) Follow(hub *Hub, topic string) error {
+	events, cancel := hub.Subscribe(topic, st.LastEventID())
+	defer cancel()
+	for {
+		select {
+		case <-st.done:
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := st.Send(e.Name, e.ID, e.Data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// An Event is an event published to a Hub.
+type Event struct {
+	Name string
+	ID   string
+	Data interface{}
+}
+
+// A Hub broadcasts events to the subscribers of their topic, like the
+// event streams of the clients following it. It keeps the last events of
+// each topic for the clients that reconnect.
+type Hub struct {
+	mu      sync.Mutex
+	topics  map[string]*hubTopic
+	history int
+	seq     uint64
+}
+
+type hubTopic struct {
+	subs   map[chan Event]bool
+	events []Event
+	seqs   []uint64
+}
+
+// hubBuffer is the number of events a subscriber may lag behind.
+const hubBuffer = 64
+
+// NewHub returns a hub keeping the last history events of each topic.
+func NewHub(history int) *Hub {
+	return &Hub{topics: map[string]*hubTopic{}, history: history}
+}
+
+func (h *Hub) topic(name string) *hubTopic {
+	t := h.topics[name]
+	if t == nil {
+		t = &hubTopic{subs: map[chan Event]bool{}}
+		h.topics[name] = t
+	}
+	return t
+}
+
+// prune removes topic t when it has neither subscribers nor history, so
+// that topics made for a client do not pile up.
+func (h *Hub) prune(name string, t *hubTopic) {
+	if len(t.subs) == 0 && len(t.events) == 0 && h.topics[name] == t {
+		delete(h.topics, name)
+	}
+}
+
+// Publish sends an event to the subscribers of topic, from any part of
+// the application: an HTTP or Duo handler, an NSQ consumer. It returns
+// the ID given to the event.
+func (h *Hub) Publish(topic, event string, data interface{}) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	e := Event{Name: event, ID: strconv.FormatUint(h.seq, 10), Data: data}
+	t := h.topic(topic)
+	if h.history > 0 {
+		t.events = append(t.events, e)
+		t.seqs = append(t.seqs, h.seq)
+		if len(t.events) > h.history {
+			t.events = t.events[1:]
+			t.seqs = t.seqs[1:]
+		}
+	}
+	for ch := range t.subs {
+		select {
+		case ch <- e:
+		default:
+			// too slow: let it go, it resumes from the history
+			delete(t.subs, ch)
+			close(ch)
+		}
+	}
+	h.prune(topic, t)
+	return e.ID
+}
+
+// Subscribe returns the events of topic from now on, preceded by the
+// ones kept that came after lastID when it is not empty, and a func to
+// call when done. The channel is closed if the subscriber lags too far
+// behind.
+func (h *Hub) Subscribe(topic, lastID string) (<-chan Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := h.topic(topic)
+	var missed []Event
+	if last, err := strconv.ParseUint(lastID, 10, 64); err == nil {
+		for i, seq := range t.seqs {
+			if seq > last {
+				missed = t.events[i:]
+				break
+			}
+		}
+	}
+
+	ch := make(chan Event, len(missed)+hubBuffer)
+	for _, e := range missed {
+		ch <- e
+	}
+	t.subs[ch] = true
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if t.subs[ch] {
+			delete(t.subs, ch)
+			close(ch)
+		}
+		h.prune(topic, t)
+	}
+}
+
+// NsqHandler returns a handler of NSQ messages publishing their bodies to
+// topic as event, for Nsq.Subscribe.
+func (h *Hub) NsqHandler(topic, event string) nsq.HandlerFunc {
+	return func(m *nsq.Message) error {
+		h.Publish(topic, event, m.Body)
+		return nil
+	}
+}
@@ -0,0 +1,133 @@
+package next
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSSESend(t *testing.T) {
+	s := newTestServer()
+	s.Get("/events", func(ctx *Context) error {
+		stream, err := ctx.SSE()
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		stream.Send("status", "1", map[string]string{"device": "a"})
+		stream.Send("", "", "two\nlines")
+		return nil
+	})
+
+	w := serve(s, "GET", "/events")
+	if w.Header().Get("Content-Type") != "text/event-stream" || !w.Flushed {
+		t.Errorf("headers %v, flushed %v", w.Header(), w.Flushed)
+	}
+	want := "id: 1\nevent: status\ndata: {\"device\":\"a\"}\n\ndata: two\ndata: lines\n\n"
+	if w.Body.String() != want {
+		t.Errorf("body %q, want %q", w.Body.String(), want)
+	}
+}
+
+func TestSSEFollow(t *testing.T) {
+	hub := NewHub(10)
+	for _, d := range []string{"a", "b", "c"} {
+		hub.Publish("devices", "status", d)
+	}
+
+	s := newTestServer()
+	s.Get("/events", func(ctx *Context) error {
+		stream, err := ctx.SSE()
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		return stream.Follow(hub, "devices")
+	})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	r := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil || line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+	// the missed events, then the live ones
+	for _, want := range []string{"id: 2\nevent: status\ndata: b\n", "id: 3\nevent: status\ndata: c\n"} {
+		if got := readEvent(); got != want {
+			t.Errorf("event %q, want %q", got, want)
+		}
+	}
+	hub.Publish("devices", "status", "d")
+	if got := readEvent(); got != "id: 4\nevent: status\ndata: d\n" {
+		t.Errorf("live event %q", got)
+	}
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	hub := NewHub(0)
+	events, cancel := hub.Subscribe("t", "")
+	defer cancel()
+	for i := 0; i <= hubBuffer; i++ {
+		hub.Publish("t", "", i)
+	}
+	n := 0
+	for range events {
+		n++
+	}
+	if n != hubBuffer {
+		t.Errorf("got %d events before being dropped, want %d", n, hubBuffer)
+	}
+}
+
+func TestSSECloseOnReturn(t *testing.T) {
+	s := newTestServer()
+	streams := make(chan *EventStream, 1)
+	s.Get("/events", func(ctx *Context) error {
+		stream, err := ctx.SSE()
+		streams <- stream
+		// returns without closing the stream
+		return err
+	})
+	serve(s, "GET", "/events")
+	select {
+	case <-(<-streams).Done():
+	default:
+		t.Error("stream left open after the handler returned")
+	}
+}
+
+func TestHubPrune(t *testing.T) {
+	hub := NewHub(0)
+	_, cancel := hub.Subscribe("client-1", "")
+	hub.Publish("client-1", "", "hi")
+	cancel()
+	hub.Publish("nobody", "", "hi")
+	if len(hub.topics) != 0 {
+		t.Errorf("%d topics left without subscribers", len(hub.topics))
+	}
+
+	kept := NewHub(1)
+	_, cancel = kept.Subscribe("t", "")
+	kept.Publish("t", "", "hi")
+	cancel()
+	if len(kept.topics) != 1 {
+		t.Error("topic with history removed")
+	}
+}
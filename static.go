@@ -0,0 +1,234 @@
+package next
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StaticOptions configures the files served by Server.Static.
+type StaticOptions struct {
+	// Index is the file served for a directory, "index.html" by default.
+	Index string
+	// Browse lists the directories without an index file; they are not
+	// found otherwise.
+	Browse bool
+	// MaxAge sets the max-age of the Cache-Control header, in seconds.
+	// The header is left out when zero.
+	MaxAge int
+}
+
+// Static serves the files of dir under prefix, as in
+// s.Static("/assets", "./public"). Responses carry an ETag and a
+// Last-Modified header, conditional requests are answered with 304 Not
+// Modified and byte ranges are supported. When the client accepts it, a
+// precompressed sibling of a file, "app.js.br" or "app.js.gz" for "app.js",
+// is served instead. Paths leaving dir, through ".." or symbolic links,
+// and files starting with a dot are not found.
+func (s *Server) Static(prefix, dir string, opts ...StaticOptions) *Route {
+	sd := &staticDir{root: dir}
+	if len(opts) > 0 {
+		sd.StaticOptions = opts[0]
+	}
+	if sd.Index == "" {
+		sd.Index = "index.html"
+	}
+	return s.Get(strings.TrimRight(prefix, "/")+"/*filepath", sd.serve)
+}
+
+type staticDir struct {
+	root string
+	StaticOptions
+}
+
+func (sd *staticDir) serve(ctx *Context, file string) {
+	name, ok := sd.resolve(file)
+	if !ok {
+		ctx.NotFound("Page not found")
+		return
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		ctx.NotFound("Page not found")
+		return
+	}
+
+	if info.IsDir() {
+		// relative links of the page need the trailing slash
+		if p := ctx.Request.URL.Path; !strings.HasSuffix(p, "/") {
+			http.Redirect(ctx.ResponseWriter, ctx.Request, p+"/", http.StatusMovedPermanently)
+			return
+		}
+		index := filepath.Join(name, sd.Index)
+		if info, err = os.Stat(index); err == nil && !info.IsDir() {
+			name = index
+		} else if sd.Browse {
+			sd.list(ctx, name)
+			return
+		} else {
+			ctx.NotFound("Page not found")
+			return
+		}
+	}
+	sd.serveFile(ctx, name, info)
+}
+
+// resolve returns the file of dir for the request path p, and false when
+// it is hidden or lies out of dir.
+func (sd *staticDir) resolve(p string) (string, bool) {
+	p = path.Clean("/" + p)
+	if strings.ContainsAny(p, "\x00\\") || strings.Contains(p, "/.") {
+		return "", false
+	}
+	name := filepath.Join(sd.root, filepath.FromSlash(p))
+
+	root, err := filepath.EvalSymlinks(sd.root)
+	if err != nil {
+		return "", false
+	}
+	real, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		// missing files are reported by the caller
+		return name, true
+	}
+	if real != root && !strings.HasPrefix(real, root+string(filepath.Separator)) {
+		return "", false
+	}
+	return name, true
+}
+
+var precompressed = []struct{ encoding, ext string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+func (sd *staticDir) serveFile(ctx *Context, name string, info os.FileInfo) {
+	ctype := mime.TypeByExtension(filepath.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+		if f, err := os.Open(name); err == nil {
+			ctype = sniffContentType(f)
+			f.Close()
+		}
+	}
+
+	accept := ctx.Request.Header.Get("Accept-Encoding")
+	encoding := ""
+	for _, pc := range precompressed {
+		ci, err := os.Stat(name + pc.ext)
+		if err != nil || ci.IsDir() {
+			continue
+		}
+		ctx.SetHeader("Vary", "Accept-Encoding", false)
+		if encoding == "" && acceptsEncoding(accept, pc.encoding) {
+			encoding, name, info = pc.encoding, name+pc.ext, ci
+		}
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		ctx.NotFound("Page not found")
+		return
+	}
+	defer f.Close()
+
+	modtime := info.ModTime().UTC()
+	etag := fmt.Sprintf(`"%x-%x"`, modtime.UnixNano(), info.Size())
+	if encoding != "" {
+		etag = etag[:len(etag)-1] + "-" + encoding + `"`
+		ctx.SetHeader("Content-Encoding", encoding, true)
+	}
+	ctx.SetHeader("Content-Type", ctype, true)
+	ctx.SetHeader("ETag", etag, true)
+	ctx.SetHeader("Last-Modified", webTime(modtime), true)
+	if sd.MaxAge > 0 {
+		ctx.SetHeader("Cache-Control", fmt.Sprintf("public, max-age=%d", sd.MaxAge), true)
+	}
+
+	if notModified(ctx.Request, etag, modtime) {
+		ctx.NotModified()
+		return
+	}
+	// ServeContent handles Range and If-Range
+	http.ServeContent(ctx.ResponseWriter, ctx.Request, "", modtime, f)
+}
+
+// notModified reports whether the conditional headers of req match the
+// current version of a file.
+func notModified(req *http.Request, etag string, modtime time.Time) bool {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return false
+	}
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+	t, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	return err == nil && !modtime.Truncate(time.Second).After(t)
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header accept
+// allows encoding.
+func acceptsEncoding(accept, encoding string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		if strings.TrimSpace(params[0]) != encoding {
+			continue
+		}
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				q, err := strconv.ParseFloat(p[2:], 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// sniffContentType returns the content type of the first bytes of r.
+func sniffContentType(r io.Reader) string {
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(r, head)
+	return http.DetectContentType(head[:n])
+}
+
+// list writes an HTML index of the files of dir.
+func (sd *staticDir) list(ctx *Context, dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		ctx.NotFound("Page not found")
+		return
+	}
+	p := html.EscapeString(ctx.Request.URL.Path)
+
+	ctx.ContentType("html")
+	ctx.WriteString("<!DOCTYPE html>\n<title>Index of " + p + "</title>\n<h1>Index of " + p + "</h1>\n<pre>\n")
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if e.IsDir() {
+			name += "/"
+		}
+		link := (&url.URL{Path: name}).String()
+		ctx.WriteString(`<a href="` + html.EscapeString(link) + `">` + html.EscapeString(name) + "</a>\n")
+	}
+	ctx.WriteString("</pre>\n")
+}
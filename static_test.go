@@ -0,0 +1,92 @@
+package next
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func staticServer(t *testing.T, opts ...StaticOptions) *Server {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "app.js"), []byte("console.log(1)"), 0644)
+	os.WriteFile(filepath.Join(dir, "app.js.gz"), []byte("gzipped"), 0644)
+	os.WriteFile(filepath.Join(dir, ".env"), []byte("secret"), 0644)
+	os.Mkdir(filepath.Join(dir, "docs"), 0755)
+	os.WriteFile(filepath.Join(dir, "docs", "a.txt"), []byte("a"), 0644)
+
+	s := newTestServer()
+	s.Static("/assets", dir, opts...)
+	return s
+}
+
+func staticGet(s *Server, path string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func TestStatic(t *testing.T) {
+	s := staticServer(t)
+
+	w := staticGet(s, "/assets/app.js")
+	if w.Code != 200 || w.Body.String() != "console.log(1)" {
+		t.Fatalf("got %d %q", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Header().Get("Content-Type"), "javascript") || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("headers %v", w.Header())
+	}
+
+	etag := w.Header().Get("ETag")
+	if w := staticGet(s, "/assets/app.js", "If-None-Match", etag); w.Code != 304 {
+		t.Errorf("If-None-Match: got %d", w.Code)
+	}
+	lm := w.Header().Get("Last-Modified")
+	if w := staticGet(s, "/assets/app.js", "If-Modified-Since", lm); w.Code != 304 {
+		t.Errorf("If-Modified-Since: got %d", w.Code)
+	}
+
+	w = staticGet(s, "/assets/app.js", "Range", "bytes=0-6")
+	if w.Code != 206 || w.Body.String() != "console" {
+		t.Errorf("Range: got %d %q", w.Code, w.Body.String())
+	}
+
+	w = staticGet(s, "/assets/app.js", "Accept-Encoding", "br, gzip")
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Body.String() != "gzipped" {
+		t.Errorf("precompressed: got %v %q", w.Header(), w.Body.String())
+	}
+	if !strings.Contains(w.Header().Get("Content-Type"), "javascript") || w.Header().Get("ETag") == etag {
+		t.Errorf("precompressed headers %v", w.Header())
+	}
+}
+
+func TestStaticNotFound(t *testing.T) {
+	s := staticServer(t)
+	for _, path := range []string{
+		"/assets/../static_test.go",
+		"/assets/%2e%2e/static_test.go",
+		"/assets/.env",
+		"/assets/docs/",
+		"/assets/missing",
+	} {
+		if w := staticGet(s, path); w.Code != 404 {
+			t.Errorf("%s: got %d", path, w.Code)
+		}
+	}
+}
+
+func TestStaticBrowse(t *testing.T) {
+	s := staticServer(t, StaticOptions{Browse: true})
+	if w := staticGet(s, "/assets/docs"); w.Code != 301 || w.Header().Get("Location") != "/assets/docs/" {
+		t.Errorf("redirect: got %d %v", w.Code, w.Header())
+	}
+	w := staticGet(s, "/assets/")
+	if body := w.Body.String(); !strings.Contains(body, `<a href="docs/">`) || strings.Contains(body, ".env") {
+		t.Errorf("listing %q", body)
+	}
+}
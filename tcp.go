@@ -2,25 +2,36 @@ package next
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"reflect"
 	"runtime"
+	"strconv"
 	"time"
 )
 
+// Tcp serves JSON messages over TCP connections. Every message gets a
+// request ID of its own, see TcpContext.RequestID: the seq a client puts in
+// its messages is not taken as the ID, as it only numbers the messages of
+// one connection and repeats across them.
 type Tcp struct {
 	Conn       map[string]*net.TCPConn
 	Config     *Config
 	Logger     *log.Logger
+	Log        *Logger
+	access     accessLog
 	routes     *Routes
 	middleware []reflect.Value
+	limits     map[string]tcpLimit
+	track      connTracker
+
+	// AccessLog makes the access log lines, over the log.format setting
+	AccessLog AccessFormatter
 }
 
 const (
@@ -34,8 +45,10 @@ func NewTcp() *Tcp {
 		Conn:       make(map[string]*net.TCPConn),
 		Config:     NewConfig(),
 		Logger:     log.New(os.Stdout, "", log.Ldate|log.Ltime),
+		Log:        Std,
 		routes:     NewRoutes(),
 		middleware: make([]reflect.Value, 0),
+		limits:     make(map[string]tcpLimit),
 	}
 
 	// Load default config if exists
@@ -134,6 +147,14 @@ func (t *Tcp) Unpack(r io.Reader) ([]byte, error) {
 }
 
 func (t *Tcp) handler(conn *net.TCPConn, body []byte) {
+	t.dispatch(t.Fd(conn), func(out []byte) error {
+		return t.Pack(conn, out)
+	}, body)
+}
+
+// dispatch calls the handler of the method of the JSON message body, from
+// the client fd. The answers of the handler go through send.
+func (t *Tcp) dispatch(fd string, send func(out []byte) error, body []byte) {
 	// Read json body
 	json := NewJson()
 	json.Load(body)
@@ -145,19 +166,25 @@ func (t *Tcp) handler(conn *net.TCPConn, body []byte) {
 		Method: requestPath,
 		Params: make(map[string]string),
 		Tcp:    t,
-		Fd:     t.Fd(conn),
-		conn:   conn,
+		Fd:     fd,
+		send:   send,
 	}
 	tm := time.Now().UTC()
-	defer t.logRequest(ctx, tm)
+	defer func() { t.logRequest(ctx, tm) }()
 
 	ctx.Params["seq"] = json.Get("seq").MustString()
-	route := t.routes.Match(requestPath, "VIA")
+	// the seq of a client repeats across connections: it goes back in seq
+	ctx.reqID = newRequestID()
+	route, params := t.routes.Match(requestPath, "VIA")
 	if route == nil {
 		ctx.WriteJSON("404", "request method not found")
 		return
 	}
 	ctx.Params["method"] = requestPath
+	ctx.route = route
+	if !t.allow(&ctx) {
+		return
+	}
 
 	if data, err := json.Get("data").Map(); err == nil {
 		if len(data) > 0 {
@@ -172,17 +199,14 @@ func (t *Tcp) handler(conn *net.TCPConn, body []byte) {
 		}
 	}
 
-	cr := route.cr
-
 	var args []reflect.Value
 	handlerType := route.handler.Type()
 	if requiresTcpContext(handlerType) {
 		args = append(args, reflect.ValueOf(&ctx))
 	}
 
-	match := cr.FindStringSubmatch(requestPath)
-	for _, arg := range match[1:] {
-		args = append(args, reflect.ValueOf(arg))
+	for _, param := range params {
+		args = append(args, reflect.ValueOf(param.Value))
 	}
 
 	_, err := t.safelyCall(route.handler, args)
@@ -199,21 +223,24 @@ func (t *Tcp) Fd(conn *net.TCPConn) string {
 
 func (t *Tcp) Pipe(conn *net.TCPConn) {
 	fd := t.Fd(conn)
+
+	// Save in map
+	if !t.track.add(t.Conn, fd, conn) {
+		conn.Close()
+		return
+	}
 	defer func() {
 		t.Logger.Printf("disconnected: %s\n", fd)
 		conn.Close()
-		delete(t.Conn, fd)
+		t.track.remove(t.Conn, fd)
 	}()
 
-	// Save in map
-	t.Conn[fd] = conn
-
 	// Read data
 	reader := bufio.NewReader(conn)
 	for {
 		body, err := t.Unpack(reader)
 		if err != nil {
-			if err == io.EOF {
+			if err == io.EOF || t.track.isClosing() {
 				return
 			}
 			t.Logger.Print(err)
@@ -221,7 +248,13 @@ func (t *Tcp) Pipe(conn *net.TCPConn) {
 
 		// Filter heart pack
 		if string(body) != "hello" {
+			if !t.track.begin(fd) {
+				return
+			}
 			t.handler(conn, body)
+			if !t.track.end(fd) {
+				return
+			}
 		}
 		reader.Reset(conn)
 	}
@@ -231,6 +264,9 @@ func (t *Tcp) Run(addr string) {
 	tcpAddr, _ := net.ResolveTCPAddr("tcp", addr)
 	tcpListener, _ := net.ListenTCP("tcp", tcpAddr)
 	defer tcpListener.Close()
+	if !t.track.listen(tcpListener) {
+		return
+	}
 
 	t.Logger.Printf("next tcp serving %s\n", addr)
 
@@ -247,6 +283,9 @@ func (t *Tcp) Run(addr string) {
 	for {
 		tcpConn, err := tcpListener.AcceptTCP()
 		if err != nil {
+			if t.track.isClosing() {
+				return
+			}
 			continue
 		}
 
@@ -255,6 +294,14 @@ func (t *Tcp) Run(addr string) {
 	}
 }
 
+// Shutdown stops tcp gracefully. It stops accepting connections, closes
+// the idle ones and lets the handlers in progress finish until ctx is
+// done. Connections still busy then are closed, and reported in a
+// *ShutdownError.
+func (t *Tcp) Shutdown(ctx context.Context) error {
+	return t.track.shutdown(ctx, t.Conn)
+}
+
 // Post adds a handler for the 'Via' TCP method for tcp.
 func (t *Tcp) Middleware(handler interface{}) {
 	switch handler.(type) {
@@ -268,8 +315,8 @@ func (t *Tcp) Middleware(handler interface{}) {
 }
 
 // Post adds a handler for the 'Via' TCP method for tcp.
-func (t *Tcp) Via(route string, handler interface{}) {
-	t.routes.Add(route, "VIA", handler)
+func (t *Tcp) Via(route string, handler interface{}) *Route {
+	return t.routes.Add(route, "VIA", handler)
 }
 
 func (t *Tcp) WriteJSON(conn *net.TCPConn, code, msg string, data ...interface{}) {
@@ -310,18 +357,31 @@ func (t *Tcp) safelyCall(function reflect.Value, args []reflect.Value) (resp []r
 }
 
 func (t *Tcp) logRequest(ctx TcpContext, sTime time.Time) {
-	//log the request
-	var logEntry bytes.Buffer
-
-	duration := time.Now().Sub(sTime)
-
-	fmt.Fprintf(&logEntry, "%s - \033[32;1m TCP %s\033[0m - %v", ctx.Fd, ctx.Method, duration)
-
-	if len(ctx.Params) > 0 {
-		fmt.Fprintf(&logEntry, " - \033[37;1mParams: %v\033[0m\n", ctx.Params)
+	var conf *routeConf
+	if ctx.route != nil {
+		conf = ctx.route.conf
+	}
+	if !sampled(conf, ctx.status) {
+		return
 	}
 
-	ctx.Tcp.Logger.Print(logEntry.String())
+	format, redact := t.access.load(t.Config, t.Logger)
+	if t.AccessLog != nil {
+		format = t.AccessLog
+	}
+	t.access.write(t.Logger, format, &AccessEntry{
+		Time:      sTime,
+		RequestID: ctx.reqID,
+		Client:    ctx.Fd,
+		Method:    "TCP",
+		Path:      ctx.Method,
+		URI:       ctx.Method,
+		Proto:     "TCP",
+		Status:    ctx.status,
+		Bytes:     ctx.size,
+		Latency:   time.Since(sTime),
+		Params:    redactParams(ctx.Params, redact),
+	})
 }
 
 // requiresContext determines whether 'handlerType' contains
@@ -348,17 +408,26 @@ func requiresTcpContext(handlerType reflect.Type) bool {
 // --------
 // Tcp Context
 // --------
+
+// TcpContext is the context of a message. Its seq, in Params, is sent back
+// as is in the answers, next to request_id, an ID made for the message
+// rather than derived from seq.
 type TcpContext struct {
 	Method string
 	Params map[string]string
 	Tcp    *Tcp
 	Fd     string
-	conn   *net.TCPConn
+	send   func(out []byte) error
+	reqID  string
+	route  *Route
+	// code and size of the answers, for the access log
+	status int
+	size   int64
 }
 
 // WriteJSON writes json data into the response object.
 func (ctx *TcpContext) WriteJSON(code, msg string, data ...interface{}) {
-	if ctx.conn == nil {
+	if ctx.send == nil {
 		return
 	}
 
@@ -375,6 +444,9 @@ func (ctx *TcpContext) WriteJSON(code, msg string, data ...interface{}) {
 	if method, ok := ctx.Params["method"]; ok {
 		json.Set("method", method)
 	}
+	if ctx.reqID != "" {
+		json.Set("request_id", ctx.reqID)
+	}
 
 	json.Set("code", code)
 	json.Set("msg", msg)
@@ -384,5 +456,9 @@ func (ctx *TcpContext) WriteJSON(code, msg string, data ...interface{}) {
 	}
 	out, _ := json.Encode()
 
-	ctx.Tcp.Pack(ctx.conn, out)
+	if ctx.status == 0 {
+		ctx.status, _ = strconv.Atoi(code)
+	}
+	ctx.size += int64(len(out))
+	ctx.send(out)
 }
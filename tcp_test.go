@@ -2,9 +2,14 @@ package next
 
 import (
 	"bytes"
+	"context"
 	_ "encoding/binary"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"testing"
+	"time"
 )
 
 func TestTcpPack(t *testing.T) {
@@ -46,3 +51,83 @@ func TestTcpUnpack(t *testing.T) {
 
 	t.Logf("sussess")
 }
+
+// pipeTcp serves one loopback connection with t and returns the client end.
+func pipeTcp(t *testing.T, tcp *Tcp) net.Conn {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := l.AcceptTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go tcp.Pipe(conn)
+	return client
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timeout")
+}
+
+func TestTcpShutdownIdle(t *testing.T) {
+	tcp := NewTcp()
+	tcp.Logger.SetOutput(ioutil.Discard)
+	client := pipeTcp(t, tcp)
+	defer client.Close()
+
+	waitFor(t, func() bool {
+		tcp.track.mu.Lock()
+		defer tcp.track.mu.Unlock()
+		return len(tcp.Conn) == 1
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tcp.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read after shutdown: %v, want EOF", err)
+	}
+}
+
+func TestTcpShutdownForced(t *testing.T) {
+	tcp := NewTcp()
+	tcp.Logger.SetOutput(ioutil.Discard)
+
+	block := make(chan struct{})
+	defer close(block)
+	tcp.Via("slow", func(ctx *TcpContext) {
+		<-block
+	})
+
+	client := pipeTcp(t, tcp)
+	defer client.Close()
+	tcp.Pack(client, []byte(`{"method": "slow", "seq": "1"}`))
+
+	waitFor(t, func() bool {
+		tcp.track.mu.Lock()
+		defer tcp.track.mu.Unlock()
+		return len(tcp.track.busy) == 1
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err, ok := tcp.Shutdown(ctx).(*ShutdownError)
+	if !ok || len(err.Forced) != 1 || err.Forced[0] != client.LocalAddr().String() {
+		t.Errorf("shutdown error = %v, want the client forced", err)
+	}
+}
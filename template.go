@@ -0,0 +1,200 @@
+package next
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// views holds the html/template files of a server, loaded from the
+// template.dir directory ("views" by default) on the first call to View.
+//
+// A file is named by its path in the directory, without the extension
+// (template.ext, ".html" by default): "users/list" for
+// "views/users/list.html". The files in layouts/ and partials/ are shared by
+// every page, so a page defines the blocks of a layout and then runs it:
+//
+//	{{define "title"}}Users{{end}}
+//	{{define "content"}}{{range .}}{{template "partials/user" .}}{{end}}{{end}}
+//	{{template "layouts/main" .}}
+type views struct {
+	mu    sync.Mutex
+	funcs template.FuncMap
+	// the shared templates, and every page parsed along with them
+	shared *template.Template
+	pages  map[string]*template.Template
+	// state of the directory when loaded, and when it was last compared,
+	// to reload it when template.reload is on
+	stamp   string
+	checked time.Time
+}
+
+// templateCheck is the time between two looks at the template directory
+// when template.reload is on.
+const templateCheck = time.Second
+
+// TemplateFuncs adds funcs to the functions available in the templates of
+// server s, next to slug, urlencode and webTime. It is to be called before
+// the first page is rendered.
+func (s *Server) TemplateFuncs(funcs template.FuncMap) {
+	s.views.mu.Lock()
+	defer s.views.mu.Unlock()
+	if s.views.funcs == nil {
+		s.views.funcs = defaultTemplateFuncs()
+	}
+	for name, fn := range funcs {
+		s.views.funcs[name] = fn
+	}
+	s.views.pages = nil
+}
+
+func defaultTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"slug": func(s string) string {
+			return Slug(s, "-")
+		},
+		"urlencode": Urlencode,
+		"webTime":   webTime,
+	}
+}
+
+// View renders the named template with data as an HTML page, as in
+// ctx.View("users/list", users). Templates are parsed once, or again when
+// one of them changes if the template.reload setting is on; the directory
+// is then looked at once a second at most. It is not named Render, which
+// answers with the encodings negotiated with the client.
+func (ctx *Context) View(name string, data interface{}) error {
+	t, err := ctx.Server.template(name)
+	if err != nil {
+		return err
+	}
+
+	// render first, so that an error does not leave half a page
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		return err
+	}
+	ctx.ContentType("html")
+	ctx.SetHeader("Content-Length", strconv.Itoa(buf.Len()), true)
+	_, err = ctx.ResponseWriter.Write(buf.Bytes())
+	return err
+}
+
+// template returns the template set of the named page.
+func (s *Server) template(name string) (*template.Template, error) {
+	v := &s.views
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	dir, ext := s.templateDir()
+	reload := false
+	if v.pages == nil {
+		reload = true
+	} else if s.Config.Bool("template.reload") && time.Since(v.checked) >= templateCheck {
+		v.checked = time.Now()
+		reload = v.stamp != dirStamp(dir, ext)
+	}
+	if reload {
+		if err := v.load(dir, ext); err != nil {
+			return nil, err
+		}
+	}
+
+	if t, ok := v.pages[name]; ok {
+		return t, nil
+	}
+	if v.shared.Lookup(name) != nil {
+		return v.shared, nil
+	}
+	return nil, fmt.Errorf("next: no template %q in %s", name, dir)
+}
+
+func (s *Server) templateDir() (dir, ext string) {
+	dir, ext = s.Config.String("template.dir"), s.Config.String("template.ext")
+	if dir == "" {
+		dir = "views"
+	}
+	if ext == "" {
+		ext = ".html"
+	}
+	return dir, ext
+}
+
+// load parses the templates of dir.
+func (v *views) load(dir, ext string) error {
+	if v.funcs == nil {
+		v.funcs = defaultTemplateFuncs()
+	}
+	stamp := dirStamp(dir, ext)
+
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ext) {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(strings.TrimSuffix(rel, ext))] = string(content)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	shared := template.New("").Funcs(v.funcs)
+	for name, content := range files {
+		if isSharedTemplate(name) {
+			if _, err := shared.New(name).Parse(content); err != nil {
+				return err
+			}
+		}
+	}
+
+	pages := map[string]*template.Template{}
+	for name, content := range files {
+		if isSharedTemplate(name) {
+			continue
+		}
+		t, err := shared.Clone()
+		if err != nil {
+			return err
+		}
+		if _, err := t.New(name).Parse(content); err != nil {
+			return err
+		}
+		pages[name] = t
+	}
+
+	v.shared, v.pages, v.stamp, v.checked = shared, pages, stamp, time.Now()
+	return nil
+}
+
+func isSharedTemplate(name string) bool {
+	return strings.HasPrefix(name, "layouts/") || strings.HasPrefix(name, "partials/")
+}
+
+// dirStamp sums up the names, sizes and modification times of the
+// templates of dir, to tell when one of them changes.
+func dirStamp(dir, ext string) string {
+	var b strings.Builder
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(path, ext) {
+			fmt.Fprintf(&b, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		}
+		return nil
+	})
+	return b.String()
+}
@@ -0,0 +1,76 @@
+package next
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func viewServer(t *testing.T, reload bool) (*Server, string) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"layouts/main.html":  `<title>{{block "title" .}}App{{end}}</title>{{template "content" .}}`,
+		"partials/user.html": `<li>{{slug .}}</li>`,
+		"users/list.html": `{{define "title"}}Users{{end}}` +
+			`{{define "content"}}<ul>{{range .}}{{template "partials/user" .}}{{end}}</ul>{{end}}` +
+			`{{template "layouts/main" .}}`,
+		"home.html": `{{define "content"}}{{range .}}{{shout .}}{{end}}{{end}}{{template "layouts/main" .}}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+
+	s := newTestServer()
+	s.Config.Set("template.dir", dir)
+	if reload {
+		s.Config.Read([]byte(`{"template": {"reload": true, "dir": "` + dir + `"}}`))
+	}
+	s.TemplateFuncs(map[string]interface{}{"shout": strings.ToUpper})
+	s.Get("/(.*)", func(ctx *Context, name string) error {
+		return ctx.View(name, []string{"Fred Zhou", "<b>"})
+	})
+	return s, dir
+}
+
+func TestView(t *testing.T) {
+	s, _ := viewServer(t, false)
+
+	w := serve(s, "GET", "/users/list")
+	want := `<title>Users</title><ul><li>fred-zhou</li><li>b</li></ul>`
+	if w.Body.String() != want || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("got %q, %v", w.Body.String(), w.Header())
+	}
+
+	if w := serve(s, "GET", "/home"); w.Body.String() != `<title>App</title>FRED ZHOU&lt;B&gt;` {
+		t.Errorf("got %q", w.Body.String())
+	}
+	if w := serve(s, "GET", "/missing"); w.Code != 500 {
+		t.Errorf("missing template: got %d", w.Code)
+	}
+}
+
+func TestViewReload(t *testing.T) {
+	for _, reload := range []bool{false, true} {
+		s, dir := viewServer(t, reload)
+		serve(s, "GET", "/home")
+
+		path := filepath.Join(dir, "home.html")
+		os.WriteFile(path, []byte(`changed`), 0644)
+		later := time.Now().Add(time.Second)
+		os.Chtimes(path, later, later)
+
+		// the directory is looked at once a second at most
+		if serve(s, "GET", "/home").Body.String() == "changed" {
+			t.Errorf("reload %v: reloaded before the check interval", reload)
+		}
+		s.views.checked = time.Time{}
+		changed := serve(s, "GET", "/home").Body.String() == "changed"
+		if changed != reload {
+			t.Errorf("reload %v: reloaded %v", reload, changed)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package next
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Context returns the context of the request, done when the client leaves
+// or the handler runs out of time, see Route.Timeout. Handlers hand it to
+// what they call, as in m.WithContext(ctx.Context()) for a Mysql m.
+func (ctx *Context) Context() context.Context {
+	return ctx.Request.Context()
+}
+
+// Timeout sets the time limit of the handler of route r, over the
+// http.timeout setting of the server, in seconds. A negative d lifts the
+// limit, for event streams and WebSockets. A handler out of time has its
+// context done, and the request is answered with 503 Service Unavailable
+// unless the handler wrote to the response already. A handler returning
+// context.DeadlineExceeded before that, from a shorter deadline of its
+// own, gets 504 Gateway Timeout.
+func (r *Route) Timeout(d time.Duration) *Route {
+	r.conf.timeout = d
+	return r
+}
+
+func (s *Server) handlerTimeout(conf routeConf) time.Duration {
+	if conf.timeout != 0 {
+		return conf.timeout
+	}
+	return time.Duration(s.Config.Int("http.timeout")) * time.Second
+}
+
+// runChain runs the middleware chain of ctx, then cleanup, and logs the
+// request. With a positive timeout, the chain runs in a goroutine of its
+// own, which the request stops waiting for when the time is up or the
+// client leaves: the request is logged then, while cleanup still waits for
+// the chain to return.
+func (s *Server) runChain(ctx *Context, timeout time.Duration, tm time.Time, cleanup func()) {
+	if timeout <= 0 {
+		defer s.logRequest(ctx, tm)
+		defer cleanup()
+		s.serveChain(ctx)
+		return
+	}
+
+	req := ctx.Request
+	tctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+	deadline, _ := tctx.Deadline()
+	body := &timeoutBody{rc: req.Body, deadline: deadline}
+	ctx.Request = req.WithContext(tctx)
+	ctx.Request.Body = body
+	// the handler gets a header of its own, which the response takes when
+	// the handler writes it, so that a timeout may answer meanwhile
+	ctx.writer.header = ctx.writer.ResponseWriter.Header().Clone()
+	ctx.writer.deadline = deadline
+	// what the access log reads of ctx, should the handler outlive the
+	// request
+	logged := *ctx
+	logged.Params = make(map[string]string, len(ctx.Params))
+	for k, v := range ctx.Params {
+		logged.Params[k] = v
+	}
+
+	done := make(chan interface{})
+	stopped := make(chan struct{})
+	go func() {
+		defer func() {
+			err := recover()
+			cleanup()
+			select {
+			case done <- err:
+			case <-stopped:
+				if err != nil {
+					s.Logger.Println("Handler panicked after its timeout", err)
+				}
+			}
+		}()
+		s.serveChain(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		s.logRequest(ctx, tm)
+		if err != nil {
+			panic(err)
+		}
+	case <-tctx.Done():
+		close(stopped)
+		ctx.writer.timeout(req.Context().Err() == nil)
+		// net/http forbids reading the body once ServeHTTP returns
+		body.stop()
+		s.logRequest(&logged, tm)
+	}
+}
+
+// timeoutBody is the request body of a handler with a time limit, which
+// fails from the deadline on, or once the request is no longer waiting for
+// the handler.
+type timeoutBody struct {
+	mu       sync.Mutex
+	rc       io.ReadCloser
+	deadline time.Time
+	stopped  bool
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped || !time.Now().Before(b.deadline) {
+		return 0, http.ErrHandlerTimeout
+	}
+	return b.rc.Read(p)
+}
+
+func (b *timeoutBody) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped {
+		return nil
+	}
+	return b.rc.Close()
+}
+
+// stop makes the reads fail, once the one under way, if any, is over.
+func (b *timeoutBody) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopped = true
+}
+
+func (s *Server) serveChain(ctx *Context) {
+	_, err := s.safelyCall(reflect.ValueOf(ctx.Next), nil)
+	if err != nil {
+		//there was a panic in a middleware
+		ctx.serverError()
+		return
+	}
+	ctx.saveSession()
+}
+
+// timeout ends the response of a handler out of time, with 503 Service
+// Unavailable when nothing is written yet and the client is still there.
+// The writes of the handler fail from then on.
+func (w *responseWriter) timeout(answer bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.timedOut = true
+	if w.written || !answer {
+		return
+	}
+	h := w.ResponseWriter.Header()
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Del("Content-Length")
+	w.status, w.written = 503, true
+	w.ResponseWriter.WriteHeader(503)
+	n, _ := w.ResponseWriter.Write([]byte("Service Unavailable"))
+	w.size += int64(n)
+}
+
+// late reports whether the handler is out of time: from its deadline on,
+// and not from when the request stops waiting for it only, so that a
+// handler done with its context never writes.
+func (w *responseWriter) late() bool {
+	if !w.timedOut && !w.deadline.IsZero() && !time.Now().Before(w.deadline) {
+		w.timedOut = true
+	}
+	return w.timedOut
+}
+
+// timeLeft returns the time until deadline, at least a nanosecond: a zero
+// timeout stands for none with the database drivers.
+func timeLeft(deadline time.Time) time.Duration {
+	if d := time.Until(deadline); d > 0 {
+		return d
+	}
+	return time.Nanosecond
+}
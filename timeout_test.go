@@ -0,0 +1,191 @@
+package next
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouteTimeout(t *testing.T) {
+	s := newTestServer()
+	result := make(chan error, 1)
+	s.Get("/slow", func(ctx *Context) {
+		<-ctx.Context().Done()
+		_, err := ctx.ResponseWriter.Write([]byte("late"))
+		if !errors.Is(ctx.Context().Err(), context.DeadlineExceeded) {
+			err = ctx.Context().Err()
+		}
+		result <- err
+	}).Timeout(20 * time.Millisecond)
+
+	w := serve(s, "GET", "/slow")
+	if err := <-result; err != http.ErrHandlerTimeout {
+		t.Errorf("late write: %v, want %v", err, http.ErrHandlerTimeout)
+	}
+	if w.Code != 503 || w.Body.String() != "Service Unavailable" {
+		t.Errorf("got %d %q, want 503 Service Unavailable", w.Code, w.Body.String())
+	}
+}
+
+func TestRouteTimeoutBody(t *testing.T) {
+	s := newTestServer()
+	result := make(chan error, 1)
+	s.Post("/upload", func(ctx *Context) {
+		<-ctx.Context().Done()
+		_, err := ctx.Request.Body.Read(make([]byte, 1))
+		result <- err
+	}).Stream().Timeout(20 * time.Millisecond)
+
+	req := httptest.NewRequest("POST", "/upload", strings.NewReader("data"))
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	if err := <-result; err != http.ErrHandlerTimeout {
+		t.Errorf("read after timeout: %v, want %v", err, http.ErrHandlerTimeout)
+	}
+}
+
+// lineWriter hands the lines of a logger over to a test.
+type lineWriter chan string
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	select {
+	case w <- string(p):
+	default:
+	}
+	return len(p), nil
+}
+
+func TestRouteTimeoutPanic(t *testing.T) {
+	s := newTestServer()
+	// panics get past the handler
+	s.Config.Read([]byte(`{"panic": true}`))
+	lines := make(lineWriter, 10)
+	s.Logger.SetOutput(lines)
+	s.Get("/", func(ctx *Context) {
+		<-ctx.Context().Done()
+		panic("late")
+	}).Timeout(10 * time.Millisecond)
+
+	serve(s, "GET", "/")
+	for {
+		select {
+		case line := <-lines:
+			if strings.Contains(line, "after its timeout") && strings.Contains(line, "late") {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("panic after the timeout not logged")
+		}
+	}
+}
+
+func TestRouteTimeoutMultipart(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	s := newTestServer()
+	s.Post("/upload", func(ctx *Context) error {
+		// spooled to disk, on the copy of the request with a deadline
+		return ctx.Request.ParseMultipartForm(0)
+	}).Stream().Timeout(time.Second)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "a.txt")
+	fw.Write([]byte("content"))
+	mw.Close()
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	if w.Code != 200 {
+		t.Fatalf("got %d", w.Code)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d files left in %s", len(files), dir)
+	}
+}
+
+func TestRouteTimeoutNoWrite(t *testing.T) {
+	s := newTestServer()
+	s.Get("/", func(ctx *Context) {}).Timeout(time.Second)
+	for i := 0; i < 50; i++ {
+		if w := serve(s, "GET", "/"); w.Code != 200 {
+			t.Fatalf("got %d, want 200", w.Code)
+		}
+	}
+}
+
+func TestRouteTimeoutHeaders(t *testing.T) {
+	s := newTestServer()
+	s.Get("/", func(ctx *Context) {
+		ctx.SetHeader("X-Kept", "1", true)
+		ctx.WriteString("fast")
+	}).Timeout(time.Second)
+
+	w := serve(s, "GET", "/")
+	if w.Code != 200 || w.Body.String() != "fast" {
+		t.Errorf("got %d %q, want 200 fast", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Kept") != "1" || w.Header().Get(RequestIDHeader) == "" {
+		t.Errorf("headers lost: %v", w.Header())
+	}
+}
+
+func TestDeadlineExceeded(t *testing.T) {
+	s := newTestServer()
+	s.Config.Read([]byte(`{"http": {"timeout": 5}}`))
+	s.Get("/", func(ctx *Context) error {
+		c, cancel := context.WithTimeout(ctx.Context(), time.Millisecond)
+		defer cancel()
+		<-c.Done()
+		return c.Err()
+	})
+
+	w := serve(s, "GET", "/")
+	if w.Code != 504 {
+		t.Errorf("got %d, want 504", w.Code)
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	s := newTestServer()
+	s.Config.Read([]byte(`{"http": {"timeout": 1}}`))
+	var deadline, unlimited bool
+	s.Get("/", func(ctx *Context) {
+		_, deadline = ctx.Context().Deadline()
+	})
+	s.Get("/stream", func(ctx *Context) {
+		_, unlimited = ctx.Context().Deadline()
+		unlimited = !unlimited
+	}).Timeout(-1)
+
+	serve(s, "GET", "/")
+	serve(s, "GET", "/stream")
+	if !deadline {
+		t.Error("no deadline from http.timeout")
+	}
+	if !unlimited {
+		t.Error("deadline despite Timeout(-1)")
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := &Mysql{}
+	if mc := m.WithContext(ctx); mc == m || mc.context() != ctx || m.context() != context.Background() {
+		t.Error("Mysql.WithContext does not return a copy bound to ctx")
+	}
+	r := &Redis{}
+	if rc := r.WithContext(ctx); rc == r || rc.ctx != ctx || r.ctx != nil {
+		t.Error("Redis.WithContext does not return a copy bound to ctx")
+	}
+}
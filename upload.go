@@ -0,0 +1,280 @@
+package next
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Default limits of multipart uploads, overridden by the upload.max_file,
+// upload.max_total and upload.memory settings. A negative setting lifts
+// the limit.
+const (
+	DefaultMaxUploadFile  = 32 << 20
+	DefaultMaxUploadTotal = 64 << 20
+	// parts larger than this are spooled to a temporary file
+	DefaultUploadMemory = 1 << 20
+)
+
+// An UploadFile is a file part of a multipart/form-data request, read by
+// ctx.File or ctx.Files. Small files are kept in memory, larger ones in a
+// temporary file removed once the request is served.
+type UploadFile struct {
+	// Field is the name of the form field.
+	Field string
+	// Filename is the base name given by the client, made safe to use as
+	// a file name.
+	Filename string
+	// ContentType is the type given by the client, or sniffed from the
+	// first bytes of the file when missing.
+	ContentType string
+	Size        int64
+	Header      textproto.MIMEHeader
+
+	data []byte
+	path string
+}
+
+// Open returns a reader of the content of f.
+func (f *UploadFile) Open() (multipart.File, error) {
+	if f.path != "" {
+		return os.Open(f.path)
+	}
+	return memFile{bytes.NewReader(f.data)}, nil
+}
+
+// SaveTo writes f to path. When path is a directory, f is saved in it
+// under its Filename.
+func (f *UploadFile) SaveTo(path string) error {
+	if dirExists(path) {
+		path = filepath.Join(path, f.Filename)
+	}
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+type memFile struct {
+	*bytes.Reader
+}
+
+func (memFile) Close() error {
+	return nil
+}
+
+// File returns the first file uploaded in the named field of a
+// multipart/form-data request, or http.ErrMissingFile.
+func (ctx *Context) File(name string) (*UploadFile, error) {
+	files, err := ctx.Files()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.Field == name {
+			return f, nil
+		}
+	}
+	return nil, http.ErrMissingFile
+}
+
+// Files returns the files uploaded in a multipart/form-data request. The
+// other fields of the form are added to ctx.Params and to
+// ctx.Request.PostForm. A file over the
+// upload.max_file limit, files over upload.max_total together, or a field
+// over upload.memory are reported as a 413 *HTTPError. The body of the request is still subject
+// to the limit of the route, see Route.MaxBody; large uploads are best
+// received on a route set to Stream, whose body is read part by part.
+func (ctx *Context) Files() ([]*UploadFile, error) {
+	if !ctx.uploaded {
+		ctx.uploaded = true
+		ctx.uploads, ctx.uploadErr = ctx.readUploads()
+	}
+	return ctx.uploads, ctx.uploadErr
+}
+
+func (ctx *Context) readUploads() ([]*UploadFile, error) {
+	mt, params, err := mime.ParseMediaType(ctx.Request.Header.Get("Content-Type"))
+	if err != nil || mt != "multipart/form-data" || params["boundary"] == "" {
+		return nil, http.ErrNotMultipart
+	}
+
+	body := ctx.Request.Body
+	if !ctx.stream {
+		body = ioutil.NopCloser(bytes.NewReader(ctx.body))
+	}
+
+	cfg := ctx.Server.Config
+	maxFile := uploadLimit(cfg.Int("upload.max_file"), DefaultMaxUploadFile)
+	total := uploadLimit(cfg.Int("upload.max_total"), DefaultMaxUploadTotal)
+	memory := uploadLimit(cfg.Int("upload.memory"), DefaultUploadMemory)
+
+	var files []*UploadFile
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, uploadError(err)
+		}
+
+		if part.FileName() == "" {
+			value, err := ioutil.ReadAll(io.LimitReader(part, memory+1))
+			part.Close()
+			if err == nil && int64(len(value)) > memory {
+				err = errUploadTooLarge
+			}
+			if err != nil {
+				return files, uploadError(err)
+			}
+			ctx.Params[part.FormName()] = string(value)
+			if ctx.Request.PostForm == nil {
+				ctx.Request.PostForm = url.Values{}
+			}
+			ctx.Request.PostForm.Add(part.FormName(), string(value))
+			continue
+		}
+
+		limit := maxFile
+		if total < limit {
+			limit = total
+		}
+		f, err := ctx.spool(part, limit, memory)
+		part.Close()
+		if f != nil {
+			files = append(files, f)
+		}
+		if err != nil {
+			return files, uploadError(err)
+		}
+		total -= f.Size
+	}
+}
+
+var errUploadTooLarge = errors.New("next: upload too large")
+
+// spool reads part into memory, moving to a temporary file past memory
+// bytes. It fails with errUploadTooLarge past limit bytes.
+func (ctx *Context) spool(part *multipart.Part, limit, memory int64) (*UploadFile, error) {
+	f := &UploadFile{
+		Field:       part.FormName(),
+		Filename:    safeFilename(part.FileName()),
+		ContentType: part.Header.Get("Content-Type"),
+		Header:      part.Header,
+	}
+
+	r := io.LimitReader(part, limit+1)
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, memory+1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	f.Size = n
+	if n <= memory {
+		f.data = buf.Bytes()
+	} else {
+		tmp, err := ioutil.TempFile(ctx.Server.Config.String("upload.dir"), "next-upload-")
+		if err != nil {
+			return nil, err
+		}
+		// removed at the end of the request, even if the copy fails
+		f.path = tmp.Name()
+		m, err := io.Copy(tmp, io.MultiReader(&buf, r))
+		tmp.Close()
+		f.Size = m
+		if err != nil {
+			return f, err
+		}
+	}
+
+	if f.Size > limit {
+		return f, errUploadTooLarge
+	}
+	if f.ContentType == "" {
+		f.ContentType = f.sniff()
+	}
+	return f, nil
+}
+
+func (f *UploadFile) sniff() string {
+	r, err := f.Open()
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer r.Close()
+	return sniffContentType(r)
+}
+
+// removeUploads removes the temporary files of the request.
+func (ctx *Context) removeUploads() {
+	for _, f := range ctx.uploads {
+		if f.path != "" {
+			os.Remove(f.path)
+		}
+	}
+	// net/http removes the files of its own request only, not of a copy
+	// with another context
+	if f := ctx.Request.MultipartForm; f != nil {
+		f.RemoveAll()
+	}
+}
+
+func uploadLimit(n int, def int64) int64 {
+	switch {
+	case n < 0:
+		return 1<<63 - 2
+	case n == 0:
+		return def
+	}
+	return int64(n)
+}
+
+func uploadError(err error) error {
+	if errors.Is(err, errUploadTooLarge) || isBodyTooLarge(err) {
+		he := NewHTTPError(413, "", "Request Entity Too Large")
+		he.Err = err
+		return he
+	}
+	he := NewHTTPError(400, "bad_request", "malformed multipart body")
+	he.Err = err
+	return he
+}
+
+// safeFilename returns the base name of a file name sent by a client, with
+// path separators, control characters and leading dots removed.
+func safeFilename(name string) string {
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"|?*`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+	if name == "" {
+		return "file"
+	}
+	return name
+}
@@ -0,0 +1,106 @@
+package next
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func uploadRequest(s *Server, files map[string]string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("device", "d1")
+	for name, content := range files {
+		fw, _ := mw.CreateFormFile("firmware", name)
+		fw.Write([]byte(content))
+	}
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+func TestUploadFile(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer()
+	s.Config.Read([]byte(`{"upload": {"memory": 4}}`))
+
+	var spooled string
+	s.Post("/upload", func(ctx *Context) error {
+		f, err := ctx.File("firmware")
+		if err != nil {
+			return err
+		}
+		spooled = f.path
+		if f.Filename != "fw.bin" || f.Size != 10 || ctx.Params["device"] != "d1" {
+			t.Errorf("got %+v, device %q", f, ctx.Params["device"])
+		}
+		return f.SaveTo(dir)
+	}).Stream()
+
+	if w := uploadRequest(s, map[string]string{"../../fw.bin": "0123456789"}); w.Code != 200 {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, "fw.bin"))
+	if err != nil || string(got) != "0123456789" {
+		t.Errorf("saved %q, %v", got, err)
+	}
+	if spooled == "" {
+		t.Error("file was not spooled to disk")
+	} else if _, err := os.Stat(spooled); !os.IsNotExist(err) {
+		t.Error("temporary file was not removed")
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	s := newTestServer()
+	s.Config.Read([]byte(`{"upload": {"max_file": 8, "max_total": 12}}`))
+	s.Post("/upload", func(ctx *Context) error {
+		_, err := ctx.Files()
+		return err
+	})
+
+	if w := uploadRequest(s, map[string]string{"a": "0123456789"}); w.Code != 413 {
+		t.Errorf("per file limit: got %d", w.Code)
+	}
+	if w := uploadRequest(s, map[string]string{"a": "01234567", "b": "01234567"}); w.Code != 413 {
+		t.Errorf("total limit: got %d", w.Code)
+	}
+	if w := uploadRequest(s, map[string]string{"a": "0123", "b": "0123"}); w.Code != 200 {
+		t.Errorf("within limits: got %d %s", w.Code, w.Body.String())
+	}
+
+	// the device field is 2 bytes
+	s = newTestServer()
+	s.Config.Read([]byte(`{"upload": {"memory": 1}}`))
+	s.Post("/upload", func(ctx *Context) error {
+		_, err := ctx.Files()
+		return err
+	})
+	if w := uploadRequest(s, nil); w.Code != 413 {
+		t.Errorf("field limit: got %d", w.Code)
+	}
+}
+
+func TestSafeFilename(t *testing.T) {
+	for in, want := range map[string]string{
+		"photo.jpg":        "photo.jpg",
+		"../../etc/passwd": "passwd",
+		`C:\Users\x\a.png`: "a.png",
+		"..":               "file",
+		".htaccess":        "htaccess",
+		"a\x00b<c>.txt":    "abc.txt",
+		"":                 "file",
+	} {
+		if got := safeFilename(in); got != want {
+			t.Errorf("safeFilename(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
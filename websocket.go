@@ -0,0 +1,543 @@
+package next
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Message types of WebSocket frames.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// Status codes of WebSocket close frames.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseTooBig          = 1009
+	CloseInternalError   = 1011
+)
+
+// ErrWebSocketClosed is returned by writes to a closed WebSocket.
+var ErrWebSocketClosed = errors.New("next: websocket closed")
+
+// CloseError is returned by ReadMessage when the WebSocket is closed by
+// the client, or because of what the client sent.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("next: websocket closed: %d %s", e.Code, e.Text)
+}
+
+// WebSocketOptions configures the WebSockets of a route.
+type WebSocketOptions struct {
+	// Origins lists the origins of the pages allowed to connect, as
+	// CORSOptions.Origins does; only the host of the request by default.
+	// Clients sending no Origin, which are not browsers, are let in.
+	Origins []string
+	// Subprotocols lists the subprotocols spoken, in order of preference.
+	Subprotocols []string
+	// MaxMessage is the size limit of the messages received, 1MB by
+	// default. Larger ones close the WebSocket with CloseTooBig.
+	MaxMessage int64
+	// PingInterval is the time between the pings sent to the client, 30
+	// seconds by default.
+	PingInterval time.Duration
+	// ReadTimeout is how long the client may stay silent, pongs included,
+	// before it is taken for gone: a minute by default.
+	ReadTimeout time.Duration
+	// WriteTimeout is the time limit of a write, 10 seconds by default.
+	WriteTimeout time.Duration
+}
+
+func (o WebSocketOptions) withDefaults() WebSocketOptions {
+	if o.MaxMessage == 0 {
+		o.MaxMessage = 1 << 20
+	}
+	if o.PingInterval == 0 {
+		o.PingInterval = 30 * time.Second
+	}
+	if o.ReadTimeout == 0 {
+		o.ReadTimeout = time.Minute
+	}
+	if o.WriteTimeout == 0 {
+		o.WriteTimeout = 10 * time.Second
+	}
+	return o
+}
+
+// A WebSocket is the server end of a WebSocket connection, RFC 6455. One
+// goroutine may read it while others write.
+type WebSocket struct {
+	// Subprotocol is the subprotocol agreed with the client, if any
+	Subprotocol string
+
+	conn   net.Conn
+	br     *bufio.Reader
+	o      *WebSocketOptions
+	closed chan struct{}
+	once   sync.Once
+
+	wmu       sync.Mutex
+	sentClose bool
+}
+
+// WebSocket adds a handler for the WebSocket connections on route of
+// server s. The handler is given the connection once the handshake is
+// done, and the connection is closed when it returns. The route has no
+// time limit, see Route.Timeout.
+//
+//	s.WebSocket("/chat/:room", func(ctx *next.Context, ws *next.WebSocket) {
+//		for {
+//			_, msg, err := ws.ReadMessage()
+//			if err != nil {
+//				return
+//			}
+//			ws.WriteMessage(next.TextMessage, msg)
+//		}
+//	})
+func (s *Server) WebSocket(route string, handler func(ctx *Context, ws *WebSocket), opts ...WebSocketOptions) *Route {
+	return s.Get(route, webSocketHandler(handler, opts)).Timeout(-1)
+}
+
+// WebSocket adds a handler for the WebSocket connections on route in
+// group g, see Server.WebSocket.
+func (g *Group) WebSocket(route string, handler func(ctx *Context, ws *WebSocket), opts ...WebSocketOptions) *Route {
+	return g.Get(route, webSocketHandler(handler, opts)).Timeout(-1)
+}
+
+func webSocketHandler(handler func(ctx *Context, ws *WebSocket), opts []WebSocketOptions) interface{} {
+	var o WebSocketOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	o = o.withDefaults()
+
+	// the path parameters are read with ctx.PathParam
+	return func(ctx *Context, params ...string) {
+		ws := ctx.upgrade(&o)
+		if ws == nil {
+			return
+		}
+		s := ctx.Server
+		if !s.addWebSocket(ws) {
+			ws.CloseWith(CloseGoingAway, "server shutting down")
+			return
+		}
+		defer func() {
+			s.removeWebSocket(ws)
+			ws.Close()
+		}()
+		handler(ctx, ws)
+	}
+}
+
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// upgrade answers the WebSocket handshake of ctx and returns the
+// connection, or nil when the request is answered with an error.
+func (ctx *Context) upgrade(o *WebSocketOptions) *WebSocket {
+	h := ctx.Request.Header
+	if !hasToken(h.Values("Connection"), "upgrade") || !hasToken(h.Values("Upgrade"), "websocket") {
+		ctx.Abort(400, "Bad Request: not a WebSocket handshake")
+		return nil
+	}
+	if h.Get("Sec-WebSocket-Version") != "13" {
+		ctx.SetHeader("Sec-WebSocket-Version", "13", true)
+		ctx.Abort(426, "Upgrade Required")
+		return nil
+	}
+	key := h.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		ctx.Abort(400, "Bad Request: invalid Sec-WebSocket-Key")
+		return nil
+	}
+	if !ctx.allowWebSocketOrigin(o) {
+		ctx.Abort(403, "Forbidden: origin not allowed")
+		return nil
+	}
+
+	var protocol string
+	offered := h.Values("Sec-WebSocket-Protocol")
+	for _, p := range o.Subprotocols {
+		if hasToken(offered, p) {
+			protocol = p
+			break
+		}
+	}
+
+	hj, ok := ctx.ResponseWriter.(http.Hijacker)
+	if !ok {
+		ctx.Abort(500, "Server Error: the connection cannot be hijacked")
+		return nil
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		ctx.Server.Logger.Println("WebSocket hijack failed", err)
+		return nil
+	}
+	// the deadlines of the http server are not for WebSockets
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n" +
+		RequestIDHeader + ": " + ctx.reqID + "\r\n"
+	if protocol != "" {
+		resp += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+	conn.SetWriteDeadline(time.Now().Add(o.WriteTimeout))
+	if _, err := conn.Write([]byte(resp + "\r\n")); err != nil {
+		conn.Close()
+		return nil
+	}
+	if ctx.writer != nil {
+		ctx.writer.mu.Lock()
+		ctx.writer.status = 101
+		ctx.writer.mu.Unlock()
+	}
+
+	ws := &WebSocket{
+		Subprotocol: protocol,
+		conn:        conn,
+		br:          brw.Reader,
+		o:           o,
+		closed:      make(chan struct{}),
+	}
+	go ws.keepalive()
+	return ws
+}
+
+func (ctx *Context) allowWebSocketOrigin(o *WebSocketOptions) bool {
+	origin := ctx.Request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(o.Origins) > 0 {
+		return mustCORSPolicy(CORSOptions{Origins: o.Origins}).allowOrigin(origin)
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, ctx.Host())
+}
+
+// keepalive pings the client until the WebSocket is closed.
+func (ws *WebSocket) keepalive() {
+	t := time.NewTicker(ws.o.PingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ws.closed:
+			return
+		case <-t.C:
+			if ws.writeFrame(PingMessage, nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+// RemoteAddr returns the address of the client.
+func (ws *WebSocket) RemoteAddr() net.Addr {
+	return ws.conn.RemoteAddr()
+}
+
+// ReadMessage returns the next text or binary message of the client,
+// answering pings on the way. When the client closes the WebSocket, or
+// breaks the protocol, the error is a *CloseError.
+func (ws *WebSocket) ReadMessage() (typ int, msg []byte, err error) {
+	for {
+		ws.conn.SetReadDeadline(time.Now().Add(ws.o.ReadTimeout))
+		fin, op, payload, err := ws.readFrame(ws.o.MaxMessage - int64(len(msg)))
+		if err != nil {
+			if ce, ok := err.(*CloseError); ok {
+				ws.CloseWith(ce.Code, ce.Text)
+			} else {
+				ws.Close()
+			}
+			return 0, nil, err
+		}
+
+		switch op {
+		case PingMessage:
+			ws.writeFrame(PongMessage, payload)
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			return 0, nil, ws.closeReceived(payload)
+		case TextMessage, BinaryMessage:
+			if typ != 0 {
+				return 0, nil, ws.fail(CloseProtocolError, "message in a fragmented message")
+			}
+			typ, msg = op, payload
+		case 0:
+			if typ == 0 {
+				return 0, nil, ws.fail(CloseProtocolError, "continuation of no message")
+			}
+			msg = append(msg, payload...)
+		default:
+			return 0, nil, ws.fail(CloseProtocolError, "unknown opcode")
+		}
+
+		if fin {
+			if typ == TextMessage && !utf8.Valid(msg) {
+				return 0, nil, ws.fail(CloseInvalidPayload, "invalid UTF-8")
+			}
+			return typ, msg, nil
+		}
+	}
+}
+
+// readFrame reads a frame of the client, of up to limit bytes of payload
+// when it is a data frame.
+func (ws *WebSocket) readFrame(limit int64) (fin bool, op int, payload []byte, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(ws.br, h[:]); err != nil {
+		return
+	}
+	fin, op = h[0]&0x80 != 0, int(h[0]&0x0f)
+	if h[0]&0x70 != 0 {
+		return fin, op, nil, &CloseError{CloseProtocolError, "no extension negotiated"}
+	}
+	if h[1]&0x80 == 0 {
+		return fin, op, nil, &CloseError{CloseProtocolError, "unmasked client frame"}
+	}
+
+	n := int64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.br, ext[:]); err != nil {
+			return
+		}
+		n = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.br, ext[:]); err != nil {
+			return
+		}
+		n = int64(binary.BigEndian.Uint64(ext[:]))
+		if n < 0 {
+			return fin, op, nil, &CloseError{CloseProtocolError, "invalid length"}
+		}
+	}
+	if op >= CloseMessage {
+		if n > 125 || !fin {
+			return fin, op, nil, &CloseError{CloseProtocolError, "invalid control frame"}
+		}
+	} else if n > limit {
+		return fin, op, nil, &CloseError{CloseTooBig, "message too big"}
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(ws.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// closeReceived answers the close frame of the client and closes the
+// connection.
+func (ws *WebSocket) closeReceived(payload []byte) error {
+	ce := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return ws.fail(CloseProtocolError, "invalid close frame")
+	case len(payload) >= 2:
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Text = string(payload[2:])
+		payload = payload[:2]
+	}
+	ws.writeFrame(CloseMessage, payload)
+	ws.shut()
+	return ce
+}
+
+func (ws *WebSocket) fail(code int, text string) error {
+	ws.CloseWith(code, text)
+	return &CloseError{code, text}
+}
+
+// WriteMessage sends a text or binary message to the client.
+func (ws *WebSocket) WriteMessage(typ int, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("next: websocket message type %d", typ)
+	}
+	return ws.writeFrame(typ, data)
+}
+
+// WriteJSON sends v to the client as JSON, in a text message.
+func (ws *WebSocket) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.writeFrame(TextMessage, data)
+}
+
+func (ws *WebSocket) writeFrame(op int, payload []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+
+	if ws.sentClose {
+		return ErrWebSocketClosed
+	}
+	if op == CloseMessage {
+		ws.sentClose = true
+	}
+
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | byte(op)
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = header[:4]
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = header[:10]
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	ws.conn.SetWriteDeadline(time.Now().Add(ws.o.WriteTimeout))
+	bufs := net.Buffers{header, payload}
+	_, err := bufs.WriteTo(ws.conn)
+	return err
+}
+
+// Close closes the WebSocket with CloseNormal.
+func (ws *WebSocket) Close() error {
+	return ws.CloseWith(CloseNormal, "")
+}
+
+// CloseWith sends a close frame of code and text to the client, unless
+// one was sent already, and closes the connection.
+func (ws *WebSocket) CloseWith(code int, text string) error {
+	if len(text) > 123 {
+		text = text[:123]
+	}
+	payload := make([]byte, 2+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], text)
+
+	err := ws.writeFrame(CloseMessage, payload)
+	ws.shut()
+	if err == ErrWebSocketClosed {
+		return nil
+	}
+	return err
+}
+
+func (ws *WebSocket) shut() {
+	ws.once.Do(func() {
+		close(ws.closed)
+		ws.conn.Close()
+	})
+}
+
+// webSockets are the open WebSockets of a server, closed on Shutdown.
+type webSockets struct {
+	closing bool
+	m       map[*WebSocket]bool
+}
+
+func (s *Server) addWebSocket(ws *WebSocket) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sockets.closing {
+		return false
+	}
+	if s.sockets.m == nil {
+		s.sockets.m = make(map[*WebSocket]bool)
+	}
+	s.sockets.m[ws] = true
+	return true
+}
+
+func (s *Server) removeWebSocket(ws *WebSocket) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sockets.m, ws)
+}
+
+// closeWebSockets closes the WebSockets of s with CloseGoingAway. The
+// http server does not know of them once hijacked.
+func (s *Server) closeWebSockets() {
+	s.mu.Lock()
+	s.sockets.closing = true
+	open := make([]*WebSocket, 0, len(s.sockets.m))
+	for ws := range s.sockets.m {
+		open = append(open, ws)
+	}
+	s.mu.Unlock()
+
+	for _, ws := range open {
+		ws.CloseWith(CloseGoingAway, "server shutting down")
+	}
+}
+
+// ServeWebSocket serves the JSON protocol of t on ws: each text message
+// is an envelope of a call, {"method": ..., "seq": ..., "data": {...}},
+// handed to the handler set for the method with t.Via, whose answers go
+// back as text messages. Browsers then share the handlers of the TCP
+// clients:
+//
+//	s.WebSocket("/ws", tcp.ServeWebSocket)
+func (t *Tcp) ServeWebSocket(ctx *Context, ws *WebSocket) {
+	fd := ws.RemoteAddr().String()
+	send := func(out []byte) error {
+		return ws.WriteMessage(TextMessage, out)
+	}
+	for {
+		typ, msg, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if typ != TextMessage {
+			ws.CloseWith(CloseUnsupportedData, "text messages only")
+			return
+		}
+		// Filter heart pack
+		if string(msg) != "hello" {
+			t.dispatch(fd, send, msg)
+		}
+	}
+}
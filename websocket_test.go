@@ -0,0 +1,190 @@
+package next
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsClient is the client end of a WebSocket, for tests.
+type wsClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, url, path string, header ...string) (*wsClient, *http.Response) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req := "GET " + path + " HTTP/1.1\r\nHost: " + strings.TrimPrefix(url, "http://") + "\r\n" +
+		"Connection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	for i := 0; i+1 < len(header); i += 2 {
+		req += header[i] + ": " + header[i+1] + "\r\n"
+	}
+	conn.Write([]byte(req + "\r\n"))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &wsClient{conn, br}, resp
+}
+
+func (c *wsClient) send(op int, fin bool, payload []byte) {
+	b := []byte{byte(op), 0x80}
+	if fin {
+		b[0] |= 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		b[1] |= byte(n)
+	default:
+		b[1] |= 126
+		b = append(b, byte(n>>8), byte(n))
+	}
+	mask := []byte{1, 2, 3, 4}
+	b = append(b, mask...)
+	for i, c := range payload {
+		b = append(b, c^mask[i%4])
+	}
+	c.conn.Write(b)
+}
+
+func (c *wsClient) read() (int, []byte) {
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		return -1, nil
+	}
+	n := int(h[1] & 0x7f)
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, n)
+	io.ReadFull(c.br, payload)
+	return int(h[0] & 0x0f), payload
+}
+
+func echoServer(opts ...WebSocketOptions) (*Server, *httptest.Server) {
+	s := newTestServer()
+	s.WebSocket("/echo/:room", func(ctx *Context, ws *WebSocket) {
+		for {
+			typ, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			ws.WriteMessage(typ, append([]byte(ctx.PathParam("room")+":"), msg...))
+		}
+	}, opts...)
+	return s, httptest.NewServer(s)
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	_, srv := echoServer()
+	defer srv.Close()
+
+	c, resp := dialWebSocket(t, srv.URL, "/echo/lobby")
+	if resp.StatusCode != 101 || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake %d %v", resp.StatusCode, resp.Header)
+	}
+
+	c.send(TextMessage, true, []byte("hi"))
+	if op, msg := c.read(); op != TextMessage || string(msg) != "lobby:hi" {
+		t.Errorf("got %d %q", op, msg)
+	}
+
+	// fragmented, with a ping in the middle
+	c.send(TextMessage, false, []byte("hel"))
+	c.send(PingMessage, true, []byte("p"))
+	c.send(0, true, []byte("lo"))
+	if op, msg := c.read(); op != PongMessage || string(msg) != "p" {
+		t.Errorf("got %d %q, want pong", op, msg)
+	}
+	if _, msg := c.read(); string(msg) != "lobby:hello" {
+		t.Errorf("got %q", msg)
+	}
+
+	c.send(CloseMessage, true, []byte{0x03, 0xe8})
+	if op, msg := c.read(); op != CloseMessage || binary.BigEndian.Uint16(msg) != CloseNormal {
+		t.Errorf("got %d %v, want close", op, msg)
+	}
+}
+
+func TestWebSocketLimits(t *testing.T) {
+	_, srv := echoServer(WebSocketOptions{MaxMessage: 8})
+	defer srv.Close()
+
+	c, _ := dialWebSocket(t, srv.URL, "/echo/a")
+	c.send(BinaryMessage, true, make([]byte, 9))
+	if op, msg := c.read(); op != CloseMessage || binary.BigEndian.Uint16(msg) != CloseTooBig {
+		t.Errorf("got %d %v, want close 1009", op, msg)
+	}
+
+	c, _ = dialWebSocket(t, srv.URL, "/echo/a")
+	c.send(TextMessage, true, []byte{0xff})
+	if op, msg := c.read(); op != CloseMessage || binary.BigEndian.Uint16(msg) != CloseInvalidPayload {
+		t.Errorf("got %d %v, want close 1007", op, msg)
+	}
+
+	_, resp := dialWebSocket(t, srv.URL, "/echo/a", "Origin", "http://evil.example")
+	if resp.StatusCode != 403 {
+		t.Errorf("foreign origin: %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestWebSocketPing(t *testing.T) {
+	_, srv := echoServer(WebSocketOptions{PingInterval: 10 * time.Millisecond})
+	defer srv.Close()
+
+	c, _ := dialWebSocket(t, srv.URL, "/echo/a")
+	if op, _ := c.read(); op != PingMessage {
+		t.Errorf("got %d, want ping", op)
+	}
+}
+
+func TestWebSocketShutdown(t *testing.T) {
+	s, srv := echoServer()
+	defer srv.Close()
+
+	c, _ := dialWebSocket(t, srv.URL, "/echo/a")
+	c.send(TextMessage, true, []byte("hi"))
+	c.read()
+
+	s.Shutdown(context.Background())
+	if op, msg := c.read(); op != CloseMessage || binary.BigEndian.Uint16(msg) != CloseGoingAway {
+		t.Errorf("got %d %v, want close 1001", op, msg)
+	}
+}
+
+func TestTcpServeWebSocket(t *testing.T) {
+	tcp := NewTcp()
+	tcp.Logger.SetOutput(ioutil.Discard)
+	tcp.Via("hello", func(ctx *TcpContext) {
+		ctx.WriteJSON("200", "hello "+ctx.Params["name"])
+	})
+	s := newTestServer()
+	s.WebSocket("/ws", tcp.ServeWebSocket)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	c, _ := dialWebSocket(t, srv.URL, "/ws")
+	c.send(TextMessage, true, []byte(`{"method": "hello", "seq": "7", "data": {"name": "ws"}}`))
+	_, msg := c.read()
+	json := NewJson()
+	json.Load(msg)
+	if json.Get("seq").MustString() != "7" || json.Get("msg").MustString() != "hello ws" {
+		t.Errorf("answer %s", msg)
+	}
+}